@@ -18,14 +18,107 @@ type GlobalObj struct {
 	Name      string         //当前服务器的名称
 
 	//Zinx
-	Version          string //当前Zinx的版本号
-	MaxConn          int    //当前服务器主机允许的最大链接数
-	MaxPacketSize    uint32 //当前Zinx框架数据包的最大值
-	WorkerPoolSize   uint32 //当前业务工作worker池的Goroutine数量
-	MaxWorkerTaskLen uint32 //Zinx框架用户最多开辟多少个Worker（限定条件）
+	Version              string //当前Zinx的版本号
+	MaxConn              int    //当前服务器主机允许的最大链接数
+	MaxPacketSize        uint32 //当前Zinx框架数据包的最大值
+	WorkerPoolSize       uint32 //当前业务工作worker池的Goroutine数量
+	MaxWorkerTaskLen     uint32 //Zinx框架用户最多开辟多少个Worker（限定条件）
+	MaxHandlerGoroutines uint32 //WorkerPoolSize为0时同时处理消息的Goroutine数量上限，0表示不限制
+	MaxInFlightPerConn   uint32 //每个链接同时在处理中的消息数上限，达到上限时暂停读取该链接，0表示不限制
+	ConnMgrShardCount    uint32 //链接管理模块的分片数量，链接按connID分散到各分片
 
+	//Socket
+	TCPNoDelay         bool //是否开启TCP_NODELAY(关闭Nagle算法)
+	TCPKeepAlive       bool //是否开启TCP keepalive
+	TCPKeepAlivePeriod int  //TCP keepalive探测间隔(秒)，0表示使用Go的默认值
+	SocketRecvBuffer   int  //SO_RCVBUF(字节)，0表示使用系统默认值
+	SocketSendBuffer   int  //SO_SNDBUF(字节)，0表示使用系统默认值
+	SocketLinger       int  //SO_LINGER(秒)，-1表示使用系统默认值，0表示关闭时丢弃未发送的数据
+	TCPUserTimeout     int  //TCP_USER_TIMEOUT(毫秒)，仅Linux，0表示不设置
+	ListenBacklog      int  //监听socket的backlog，仅Linux，0表示使用系统默认值
+	TCPDeferAccept     int  //TCP_DEFER_ACCEPT(秒)，客户端发来数据后才accept，仅Linux，0表示不设置
+	TCPFastOpen        int  //TCP_FASTOPEN的队列长度，仅Linux，0表示不开启
+
+	//Connection
+	ReadBufferSize          uint32 //每个链接读缓冲区的大小
+	MaxMsgChanLen           uint32 //每个链接有缓冲发送队列的长度
+	SendBuffFullPolicy      string //有缓冲发送队列满时的处理策略
+	SendBuffTimeout         int    //block策略下等待发送队列空位的超时时间(毫秒)，<=0表示一直等待
+	MaxWriteBatchBytes      uint32 //Writer合并发送时单次writev的最大字节数，0表示不合并
+	WriteBatchLatency       int    //Writer合并发送时等待更多消息的最长时间(微秒)，0表示不等待
+	SessionResumeGrace      int    //链接断开后保存会话等待客户端重连的宽限期(秒)，0表示不开启会话恢复
+	SessionResumeWait       int    //开启会话恢复时等待客户端第一条消息的时间(毫秒)，超时按新链接调用OnConnStart
+	MaxReliableBuffLen      uint32 //每个链接可靠消息重传缓冲的最大消息数，0表示不限制
+	GracefulCloseTimeout    int    //CloseWithMessage优雅关闭链接的超时时间(毫秒)
+	GracefulCloseWrite      bool   //优雅关闭时是否先半关闭写端，等待客户端关闭后再关闭链接
+	Compression             string //发送消息使用的压缩算法名称(gzip、deflate或在Server启动前自行注册的算法)，为空表示不压缩
+	CompressThreshold       uint32 //消息数据达到该长度才压缩
+	MaxDecompressLen        uint32 //收到的压缩消息解压之后允许的最大长度
+	EnableEncryption        bool   //是否开启加密通道，链接建立后先进行X25519密钥交换，之后的消息都使用AES-GCM加密
+	EncryptionSignKey       string //开启加密时必须配置：服务器长期签名私钥(Ed25519种子，32字节hex编码)，用来签名每次密钥交换的临时公钥
+	CallTimeout             int    //Call等待客户端回复的默认超时时间(毫秒)，ctx设置了超时时间时以ctx为准
+	PriorityStarvationRatio uint32 //高优先级连续发送多少条消息后让低优先级发送一条，0表示严格按优先级
+	MaxPubSubQueueLen       uint32 //发布订阅中每个订阅者队列的长度，队列满时丢弃发给该订阅者的消息
+	DuplicateLoginPolicy    string //同一用户重复登录(Bind)时的处理策略
+	DuplicateLoginNotice    string //踢掉旧链接时发送给旧链接的提示内容
+	TimerTick               int    //链接定时器共享的时间轮每一格的时间(毫秒)，也是定时器的精度
+
+	//Bandwidth
+	MaxSendBytesPerSec       int //每个链接每秒最多发送的字节数，0表示不限制，可以在运行时按链接调整
+	MaxRecvBytesPerSec       int //每个链接每秒最多接收的字节数，0表示不限制
+	ServerMaxSendBytesPerSec int //所有链接合计每秒最多发送的字节数，0表示不限制
+	ServerMaxRecvBytesPerSec int //所有链接合计每秒最多接收的字节数，0表示不限制
+
+	//SlowConsumer
+	SlowConsumerQueueLen     uint32   //发送积压(包括阻塞在SendMsg上的调用者)达到该长度时判定为慢速消费者，0表示不检查
+	SlowConsumerWriteLatency int      //单次写入socket耗时(包括仍未返回的写入)达到该时间(毫秒)时判定为慢速消费者，0表示不检查
+	SlowConsumerWriteTimeout int      //单次写入socket的超时时间(毫秒)，超时后断开链接，默认10秒，0表示不限制
+	SlowConsumerPolicy       string   //判定为慢速消费者之后的处理策略
+	NonCriticalMsgIDs        []uint32 //非关键消息的MsgID，drop策略下链接处于慢速状态时丢弃
+
+	//RateLimit
+	ConnRateLimit            RateLimit            //每个链接收到消息(包括可靠消息的确认和Call的回复)的速率限制，Rate为0表示不限制
+	MsgRateLimits            map[uint32]RateLimit //每个链接按MsgID的速率限制，例如聊天5条/秒、移动30条/秒
+	RateLimitPolicy          string               //消息超过速率限制时的处理策略
+	RateLimitMaxViolations   uint32               //disconnect策略下最近一段时间内超限多少次后断开链接
+	RateLimitViolationWindow int                  //超限次数的统计窗口(毫秒)，距离上次超限超过该时间后重新计数，0表示一直累计
 }
 
+// 令牌桶速率限制
+type RateLimit struct {
+	Rate  float64 //每秒补充的令牌数，即允许的平均速率
+	Burst uint32  //令牌桶容量，即允许的突发数量，为0时取Rate
+}
+
+// 有缓冲发送队列满时的处理策略
+const (
+	SendBuffFullBlock      = "block"       //阻塞等待队列空位，超时后返回错误
+	SendBuffFullDropNewest = "drop_newest" //丢弃当前要发送的消息
+	SendBuffFullDropOldest = "drop_oldest" //丢弃队列中最早的消息，为当前消息腾出位置，队列容量为0时按drop_newest处理
+	SendBuffFullDisconnect = "disconnect"  //认为客户端是慢速消费者，直接断开链接
+)
+
+// 同一用户重复登录时的处理策略
+const (
+	DuplicateLoginKick   = "kick"   //给旧链接发送提示消息后关闭，新链接绑定成功
+	DuplicateLoginReject = "reject" //拒绝新链接的绑定，旧链接不受影响
+	DuplicateLoginMulti  = "multi"  //允许同一用户同时绑定多个链接(多设备登录)
+)
+
+// 判定为慢速消费者之后的处理策略
+const (
+	SlowConsumerNone       = "none"       //只调用OnSlowConsumer
+	SlowConsumerDrop       = "drop"       //慢速状态期间丢弃NonCriticalMsgIDs中的消息，队列恢复后继续发送
+	SlowConsumerDisconnect = "disconnect" //直接断开链接
+)
+
+// 消息超过速率限制时的处理策略
+const (
+	RateLimitDrop       = "drop"       //直接丢弃
+	RateLimitReply      = "reply"      //丢弃并给客户端回复一条超限消息
+	RateLimitDisconnect = "disconnect" //丢弃，最近一段时间内超限RateLimitMaxViolations次后断开链接
+)
+
 // 定义一个全局的对外Globalobj
 var GlobalObject *GlobalObj
 
@@ -47,14 +140,52 @@ func (g *GlobalObj) Reload() {
 func init() {
 	//如果配置文件没有加载，默认的值
 	GlobalObject = &GlobalObj{
-		Name:             "ZinxServerAPP",
-		Version:          "V0.10",
-		TcpPort:          8999,
-		Host:             "0.0.0.0",
-		MaxConn:          1000,
-		MaxPacketSize:    4096,
-		WorkerPoolSize:   10,   //Worker工作池的队列的个数
-		MaxWorkerTaskLen: 1024, //每个worker对应的消息队列的任务的数量的最大值
+		Name:                 "ZinxServerAPP",
+		Version:              "V0.10",
+		TcpPort:              8999,
+		Host:                 "0.0.0.0",
+		MaxConn:              1000,
+		ConnMgrShardCount:    32,
+		MaxPacketSize:        4096,
+		WorkerPoolSize:       10,   //Worker工作池的队列的个数
+		MaxWorkerTaskLen:     1024, //每个worker对应的消息队列的任务的数量的最大值
+		MaxHandlerGoroutines: 10000,
+		MaxInFlightPerConn:   0,
+
+		TCPNoDelay:   true,
+		TCPKeepAlive: true,
+		SocketLinger: -1,
+
+		ReadBufferSize:          4096,
+		MaxMsgChanLen:           1024,
+		SendBuffFullPolicy:      SendBuffFullBlock,
+		SendBuffTimeout:         1000,
+		MaxWriteBatchBytes:      64 * 1024,
+		WriteBatchLatency:       0,
+		SessionResumeGrace:      0,
+		SessionResumeWait:       1000,
+		MaxReliableBuffLen:      1024,
+		GracefulCloseTimeout:    3000,
+		GracefulCloseWrite:      true,
+		Compression:             "",
+		CompressThreshold:       1024,
+		MaxDecompressLen:        1024 * 1024,
+		EnableEncryption:        false,
+		CallTimeout:             5000,
+		PriorityStarvationRatio: 8,
+		MaxPubSubQueueLen:       256,
+		DuplicateLoginPolicy:    DuplicateLoginKick,
+		DuplicateLoginNotice:    "duplicate login",
+		TimerTick:               10,
+
+		SlowConsumerQueueLen:     768,
+		SlowConsumerWriteLatency: 1000,
+		SlowConsumerWriteTimeout: 10000,
+		SlowConsumerPolicy:       SlowConsumerDrop,
+
+		RateLimitPolicy:          RateLimitDrop,
+		RateLimitMaxViolations:   10,
+		RateLimitViolationWindow: 10000,
 	}
 	//应该尝试从conf/zinx.json去加载一些用户自定义的参数
 	GlobalObject.Reload()
@@ -0,0 +1,17 @@
+package ziface
+
+/*
+消息压缩算法抽象层
+消息头带有压缩标志时，扩展头中的1字节算法ID指明使用的压缩算法
+*/
+
+type ICompressor interface {
+	//压缩算法的ID，写在消息的扩展头中，0保留不用
+	ID() uint8
+	//压缩算法的名称，用于配置
+	Name() string
+	//压缩数据
+	Compress(data []byte) ([]byte, error)
+	//解压数据，解压之后的长度超过maxLen时返回错误
+	Decompress(data []byte, maxLen int) ([]byte, error)
+}
@@ -1,6 +1,10 @@
 package ziface
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
 
 // 定义链接模块的抽象层
 type IConnection interface {
@@ -9,6 +13,15 @@ type IConnection interface {
 
 	//停止链接 结束当前链接的工作
 	Stop()
+	//优雅地停止链接 停止读取，发送完队列中已有的消息后再结束链接，超过timeout直接结束
+	StopGracefully(timeout time.Duration)
+	//发送一条消息之后优雅地关闭链接，保证该消息能送达客户端，消息无法发送时直接关闭链接
+	CloseWithMessage(msgId uint32, data []byte) error
+
+	//获取链接的上下文，链接关闭时被取消
+	Context() context.Context
+	//返回一个在链接关闭时被关闭的channel，供业务Goroutine感知链接退出
+	Done() <-chan struct{}
 
 	//获取当前链接绑定的socket conn
 	GetTCPConnection() *net.TCPConn
@@ -21,7 +34,31 @@ type IConnection interface {
 
 	//发送数据 将数据发送给远程的客户端
 	SendMsg(msgId uint32, data []byte) error
+	//发送数据 将数据放入有缓冲的发送队列，由Writer异步发送给远程的客户端
+	SendBuffMsg(msgId uint32, data []byte) error
+	//发送可靠消息 消息带有序列号，在客户端确认之前保存在重传缓冲中，会话恢复后会被重放
+	SendReliableMsg(msgId uint32, data []byte) error
+	//添加一个定时器，d之后在新的Goroutine中执行f，链接断开时自动取消
+	AfterFunc(d time.Duration, f func()) ITimer
+	//添加一个定时器，d之后在链接对应的Worker中执行f，与该链接的消息处理串行
+	AfterFuncOnWorker(d time.Duration, f func()) ITimer
+	//向客户端发送一条请求消息，并等待客户端带有相同请求ID的回复
+	//回复由Reader投递，不能在同步路由或由Reader调用的OnConnStart、OnConnResume中调用，否则一直等到超时
+	Call(ctx context.Context, msgId uint32, data []byte) ([]byte, error)
+	//按优先级发送消息，priority越小越优先，Writer总是先发送高优先级的消息
+	SendMsgWithPriority(priority uint8, msgId uint32, data []byte) error
+	//调整链接的带宽限制，每秒发送/接收的字节数，0表示不限制
+	SetBandwidthLimit(sendBytesPerSec int, recvBytesPerSec int)
+	//获取有缓冲发送队列因队列已满而丢弃的消息数量
+	GetBuffDropCount() uint64
+	//获取当前链接的流量统计快照
+	Stats() ConnStats
+
+	//替换链接的字节流过滤链，握手之后切换时应在同步路由中调用
+	SetFilters(filters ...IFilter) error
 
+	//获取链接的会话存储，链接属性都保存在这里
+	GetSession() ISession
 	//设置链接属性
 	SetProperty(key string, value interface{})
 	//获取链接属性
@@ -30,5 +67,26 @@ type IConnection interface {
 	RemoveProperty(key string)
 }
 
+// 链接的流量统计快照
+type ConnStats struct {
+	ConnID         uint32            //链接的ID
+	BytesIn        uint64            //收到的字节数
+	BytesOut       uint64            //发送的字节数
+	MsgsIn         uint64            //收到的消息数
+	MsgsOut        uint64            //发送的消息数
+	MsgIDCounts    map[uint32]uint64 //按MsgID统计的收到的消息数
+	ConnectTime    time.Time         //链接建立的时间
+	LastReadTime   time.Time         //最后一次收到消息的时间
+	LastWriteTime  time.Time         //最后一次发送消息的时间
+	SendQueueLen   int               //有缓冲发送队列中等待发送的消息数
+	BuffDropCount  uint64            //有缓冲发送队列丢弃的消息数
+	WriteBatches   uint64            //Writer发起的writev调用次数
+	InFlight       int               //当前正在处理中的消息数
+	ReadPauses     uint64            //Reader因处理中的消息达到上限而暂停的次数
+	ReadPausedTime time.Duration     //Reader累计暂停的时长
+	SlowCount      uint64            //被判定为慢速消费者的次数
+	SlowDropCount  uint64            //慢速状态期间丢弃的非关键消息数
+}
+
 // 定义一个处理链接业务的方法
 type HandleFunc func(*net.TCPConn, []byte, int) error
@@ -1,5 +1,7 @@
 package ziface
 
+import "time"
+
 //连接管理模块抽象层
 
 type IConnManager interface {
@@ -13,4 +15,55 @@ type IConnManager interface {
 	Len() int
 	//清除并终止所有的链接
 	ClearConn()
+	//遍历所有链接，f返回false时停止遍历；f在锁外调用，可以在其中Stop链接
+	Range(f func(conn IConnection) bool)
+	//得到当前所有链接的快照
+	Snapshot() []IConnection
+	//查找属性key的值等于value的链接
+	FindByProperty(key string, value interface{}) []IConnection
+	//查找远程地址以prefix开头的链接，例如 "192.168.1."
+	FindByRemoteAddrPrefix(prefix string) []IConnection
+	//得到全服的流量统计，包含已经断开的链接
+	Stats() ServerStats
+
+	//将链接绑定到用户，用户已绑定其他链接时按DuplicateLoginPolicy处理，链接断开时自动解绑
+	Bind(userID string, conn IConnection) error
+	//根据用户获取绑定的链接，多设备登录时可能有多个
+	GetByUser(userID string) ([]IConnection, error)
+	//解除链接与用户的绑定
+	Unbind(conn IConnection)
+}
+
+// 全服的流量统计
+type ServerStats struct {
+	Connections    int               //当前链接总数
+	BytesIn        uint64            //收到的字节数
+	BytesOut       uint64            //发送的字节数
+	MsgsIn         uint64            //收到的消息数
+	MsgsOut        uint64            //发送的消息数
+	MsgIDCounts    map[uint32]uint64 //按MsgID统计的收到的消息数
+	SendQueueLen   int               //当前所有链接发送队列中等待发送的消息数
+	BuffDropCount  uint64            //有缓冲发送队列丢弃的消息数
+	ReadPauses     uint64            //所有链接的Reader因处理中的消息达到上限而暂停的次数
+	ReadPausedTime time.Duration     //所有链接的Reader累计暂停的时长
+	SlowDropCount  uint64            //慢速消费者丢弃的非关键消息数
+}
+
+// 将一个链接的统计累加到全服统计中
+func (s *ServerStats) Add(cs ConnStats) {
+	s.BytesIn += cs.BytesIn
+	s.BytesOut += cs.BytesOut
+	s.MsgsIn += cs.MsgsIn
+	s.MsgsOut += cs.MsgsOut
+	s.SendQueueLen += cs.SendQueueLen
+	s.BuffDropCount += cs.BuffDropCount
+	s.ReadPauses += cs.ReadPauses
+	s.ReadPausedTime += cs.ReadPausedTime
+	s.SlowDropCount += cs.SlowDropCount
+	if s.MsgIDCounts == nil {
+		s.MsgIDCounts = make(map[uint32]uint64, len(cs.MsgIDCounts))
+	}
+	for msgID, n := range cs.MsgIDCounts {
+		s.MsgIDCounts[msgID] += n
+	}
 }
@@ -12,4 +12,6 @@ type IDataPack interface {
 	Pack(msg IMessage) ([]byte, error)
 	//拆包方法
 	UnPack([]byte) (IMessage, error)
+	//拆包扩展头方法 读完消息数据之后，按标志位从数据中解析出扩展头
+	UnPackExt(msg IMessage) error
 }
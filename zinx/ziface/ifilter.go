@@ -0,0 +1,17 @@
+package ziface
+
+import "io"
+
+/*
+字节流过滤器抽象层
+位于socket与封包拆包模块之间，对读写的字节流做变换(加密、压缩、混淆、校验等)
+多个过滤器按顺序组成过滤链，第0个最靠近socket
+*/
+
+type IFilter interface {
+	//包装读取端 返回的Reader从r中读取字节，输出变换之后的字节
+	WrapReader(r io.Reader) io.Reader
+	//包装写入端 写入返回的Writer的字节经过变换之后再写入w
+	//返回的Writer如果实现了 Flush() error，每批消息写完之后会被调用
+	WrapWriter(w io.Writer) io.Writer
+}
@@ -0,0 +1,28 @@
+package ziface
+
+//分组(房间)管理模块抽象层
+//分组中的成员为链接，链接断开时自动退出所有分组
+
+type IGroupManager interface {
+	//创建分组，分组已存在时返回错误
+	CreateGroup(name string) error
+	//销毁分组，分组中的成员全部退出
+	DestroyGroup(name string)
+	//将链接加入分组
+	Join(name string, connID uint32) error
+	//将链接移出分组
+	Leave(name string, connID uint32)
+	//将链接移出它加入的所有分组
+	LeaveAll(connID uint32)
+	//得到分组中所有成员的connID
+	Members(name string) ([]uint32, error)
+	//得到链接加入的所有分组
+	GroupsOf(connID uint32) []string
+	//得到当前分组总数
+	Len() int
+	//向分组中除exclude之外的所有成员发送消息，消息只封包一次，放入各成员的有缓冲发送队列
+	//成员的队列已满时不等待，直接丢弃
+	Broadcast(name string, msgId uint32, data []byte, exclude ...uint32) error
+	//得到广播时因成员发送队列已满等原因未能发出的消息数
+	GetDropCount() uint64
+}
@@ -16,4 +16,21 @@ type IMessage interface {
 	SetData([]byte)
 	//设置消息的长度
 	SetDataLen(uint32)
+
+	//获取消息的标志位
+	GetFlags() uint8
+	//设置消息的标志位
+	SetFlags(uint8)
+	//获取可靠消息的序列号
+	GetSeq() uint32
+	//设置可靠消息的序列号
+	SetSeq(uint32)
+	//获取压缩消息使用的压缩算法ID
+	GetCompressor() uint8
+	//设置压缩消息使用的压缩算法ID
+	SetCompressor(uint8)
+	//获取请求或回复消息的请求ID
+	GetReqID() uint32
+	//设置请求或回复消息的请求ID
+	SetReqID(uint32)
 }
@@ -1,14 +1,22 @@
 package ziface
 
+import "time"
+
 //消息管理抽象层
 
 type IMsgHandle interface {
 	//调度/执行对应的Router消息处理方法
 	DoMsgHandler(request IRequest)
-	//为消息添加具体的处理逻辑
+	//为消息添加具体的处理逻辑，msgId只能使用低24位，并且不能是系统保留的MsgID，否则panic
 	AddRouter(msgId uint32, router IRouter)
+	//为消息添加具体的处理逻辑，该消息在链接的Reader中同步处理
+	AddSyncRouter(msgId uint32, router IRouter)
+	//判断消息是否需要在链接的Reader中同步处理
+	IsSyncRouter(msgId uint32) bool
 	//启动Worker工作池
 	StartWorkerPool()
-	//将消息发送给消息任务队列处理
+	//将消息发送给消息任务队列处理，没有开启工作池时开启Goroutine处理
 	SendMsgToTaskQueue(request IRequest)
+	//得到投递消息时因Worker队列已满或Goroutine数量达到上限而阻塞的次数及累计时长
+	GetBlockedStats() (count uint64, total time.Duration)
 }
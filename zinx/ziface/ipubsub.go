@@ -0,0 +1,20 @@
+package ziface
+
+//发布订阅模块抽象层
+//主题由"."分隔的段组成，订阅时"*"匹配任意一段，"#"只能作为最后一段，匹配之后的零段或多段
+//例如 market.*.price 匹配 market.btc.price，market.# 匹配 market 及 market.btc.price
+
+type IPubSub interface {
+	//为链接订阅一个主题或主题模式
+	Subscribe(connID uint32, pattern string) error
+	//取消链接的一个订阅
+	Unsubscribe(connID uint32, pattern string)
+	//取消链接的所有订阅，链接断开时自动调用
+	UnsubscribeAll(connID uint32)
+	//得到链接的所有订阅
+	Subscriptions(connID uint32) []string
+	//向订阅了该主题的所有链接发送消息，返回放入订阅者队列的数量
+	Publish(topic string, msgId uint32, data []byte) int
+	//得到因订阅者队列已满而丢弃的消息总数
+	GetDropCount() uint64
+}
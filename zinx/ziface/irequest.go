@@ -8,7 +8,13 @@ type IRequest interface {
 	GetConnection() IConnection
 
 	//得到请求的消息数据
+	//数据缓冲在路由处理完毕后会被回收复用，需要在处理之外使用时请先拷贝一份
 	GetData() []byte
 
 	GetMsgID() uint32
+
+	//得到请求消息的请求ID，客户端没有要求回复时为0
+	GetReqID() uint32
+	//回复客户端的请求，回复消息带有相同的MsgID及请求ID
+	Reply(data []byte) error
 }
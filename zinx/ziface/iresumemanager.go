@@ -0,0 +1,30 @@
+package ziface
+
+//会话恢复管理模块抽象层
+//链接断开后在宽限期内保存会话，客户端携带令牌重连后可以恢复
+
+type IResumeManager interface {
+	//生成一个新的会话恢复令牌
+	NewToken() (string, error)
+	//链接断开时保存会话及未发送的消息，超过宽限期后自动丢弃
+	Park(token string, session ResumableSession)
+	//根据令牌取回保存的会话，取回后令牌失效
+	Resume(token string) (ResumableSession, bool)
+	//当前保存的会话数量
+	Len() int
+}
+
+// 链接断开时保存下来、等待恢复的会话
+type ResumableSession struct {
+	Session ISession //链接的会话属性
+	Pending [][]byte //断开时尚未发送给客户端的消息(已封包)
+
+	LastSeq uint32          //最后一条可靠消息的序列号
+	Unacked []ReliableFrame //尚未被客户端确认的可靠消息
+}
+
+// 一条已封包的可靠消息
+type ReliableFrame struct {
+	Seq  uint32 //可靠消息的序列号
+	Data []byte //封包后的消息
+}
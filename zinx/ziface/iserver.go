@@ -1,5 +1,7 @@
 package ziface
 
+import "time"
+
 // 抽象层
 type IServer interface {
 	//启动服务器
@@ -10,14 +12,44 @@ type IServer interface {
 	Serve()
 	//路由功能：当前的服务器注册一个路由方法，供客户端的链接进行处理使用
 	AddRouter(msgID uint32, router IRouter)
+	//路由功能：注册一个同步路由，在链接的Reader中直接执行，处理完之前不会读取该链接的下一条消息
+	AddSyncRouter(msgID uint32, router IRouter)
+	//为之后建立的链接追加一个字节流过滤器，第一个追加的最靠近socket
+	AddFilter(filter IFilter)
+	//获取新链接使用的过滤链
+	GetFilters() []IFilter
+	//调整全服的带宽限制，所有链接合计每秒发送/接收的字节数，0表示不限制
+	SetBandwidthLimit(sendBytesPerSec int, recvBytesPerSec int)
 	//获取当前Server的链接管理器
 	GetConnMgr() IConnManager
+	//获取当前Server的分组(房间)管理器
+	GetGroupMgr() IGroupManager
+	//获取当前Server的发布订阅模块
+	GetPubSub() IPubSub
+	//获取当前Server的链接定时器时间轮
+	GetTimerWheel() ITimerWheel
 	//注册OnConnStart钩子函数
+	//开启加密时在密钥交换完成之后调用；开启会话恢复时在收到客户端第一条消息(在Reader中调用)
+	//或等待SessionResumeWait超时之后调用，因此可能晚于链接建立
 	SetOnConnStart(func(connection IConnection))
-	//注册OnConnStop钩子函数
+	//注册OnConnStop钩子函数，只有调用过OnConnStart或OnConnResume的链接才会调用
 	SetOnConnStop(func(connection IConnection))
+	//注册OnConnResume钩子函数，客户端重连并恢复会话时代替OnConnStart被调用
+	SetOnConnResume(func(connection IConnection))
 	//调用OnConnStart钩子函数
 	CallOnConnStart(connection IConnection)
 	//调用OnConnStart钩子函数
 	CallOnConnStop(connection IConnection)
+	//调用OnConnResume钩子函数
+	CallOnConnResume(connection IConnection)
+	//注册OnRateLimit钩子函数，链接收到的消息超过速率限制时被调用，violations为该链接最近一段时间内(RateLimitViolationWindow)的超限次数
+	SetOnRateLimit(func(connection IConnection, msgID uint32, violations uint32))
+	//调用OnRateLimit钩子函数
+	CallOnRateLimit(connection IConnection, msgID uint32, violations uint32)
+	//注册OnSlowConsumer钩子函数，链接被判定为慢速消费者时被调用
+	SetOnSlowConsumer(func(connection IConnection, queueLen int, writeLatency time.Duration))
+	//调用OnSlowConsumer钩子函数
+	CallOnSlowConsumer(connection IConnection, queueLen int, writeLatency time.Duration)
+	//获取当前Server的会话恢复管理器，未开启会话恢复时返回nil
+	GetResumeMgr() IResumeManager
 }
@@ -0,0 +1,32 @@
+package ziface
+
+import "time"
+
+/*
+链接的会话存储抽象层
+替代原先无类型的链接属性集合，支持过期时间、原子更新及变更通知
+*/
+
+type ISession interface {
+	//获取会话属性
+	Get(key string) (interface{}, error)
+	//设置会话属性
+	Set(key string, value interface{})
+	//设置会话属性，超过ttl之后自动失效，ttl<=0表示永不过期
+	SetWithTTL(key string, value interface{}, ttl time.Duration)
+	//移除会话属性
+	Remove(key string)
+	//当属性当前值等于old时替换为new，返回是否替换成功，old为nil表示属性不存在时才设置
+	CompareAndSwap(key string, old, new interface{}) bool
+	//原子地读取并更新属性，fn返回keep=false时删除该属性，返回更新后的值
+	Update(key string, fn func(old interface{}, exists bool) (value interface{}, keep bool)) interface{}
+	//注册属性变更的钩子函数，新增、修改、删除及过期时都会被调用
+	OnChange(hook SessionHook)
+	//获取当前所有未过期属性的快照
+	Snapshot() map[string]interface{}
+	//将当前所有未过期属性序列化为JSON，便于在链接断开时记录或持久化
+	MarshalJSON() ([]byte, error)
+}
+
+// 会话属性变更的钩子函数，属性被删除或过期时newValue为nil
+type SessionHook func(key string, oldValue, newValue interface{})
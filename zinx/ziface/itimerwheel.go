@@ -0,0 +1,23 @@
+package ziface
+
+import "time"
+
+//定时器模块抽象层
+//所有链接共享一个分层时间轮，定时器按链接归类，链接断开时自动取消
+
+type ITimerWheel interface {
+	//启动时间轮
+	Start()
+	//停止时间轮，未到期的定时器不再执行
+	Stop()
+	//为链接添加一个定时器，d之后在新的Goroutine中执行f
+	AfterFunc(connID uint32, d time.Duration, f func()) ITimer
+	//取消链接的所有定时器
+	CancelConn(connID uint32)
+}
+
+// 时间轮中的一个定时器
+type ITimer interface {
+	//取消定时器，定时器已经执行或已经取消时返回false
+	Stop() bool
+}
@@ -0,0 +1,132 @@
+package znet
+
+import (
+	"context"
+	"src/zinx/utils"
+	"sync"
+	"time"
+)
+
+// 带宽限制器，按字节数的令牌桶，可以在运行时调整速率，并发安全
+// 令牌不足时允许透支，调用者等待到令牌补回为止，因此任意大小的数据都能发送
+type bandwidthLimiter struct {
+	lock   sync.Mutex
+	bucket *tokenBucket //为nil时不限制
+}
+
+// 创建带宽限制器，bytesPerSec为0表示不限制
+func newBandwidthLimiter(bytesPerSec int) *bandwidthLimiter {
+	bl := &bandwidthLimiter{}
+	bl.SetRate(bytesPerSec)
+	return bl
+}
+
+// 调整每秒允许的字节数，0表示不限制，令牌桶容量为1秒的流量
+func (bl *bandwidthLimiter) SetRate(bytesPerSec int) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	if bytesPerSec <= 0 {
+		bl.bucket = nil
+		return
+	}
+	if bl.bucket == nil {
+		bl.bucket = newTokenBucket(utils.RateLimit{Rate: float64(bytesPerSec)})
+		return
+	}
+	bl.bucket.refill(time.Now())
+	bl.bucket.rate = float64(bytesPerSec)
+	bl.bucket.burst = float64(bytesPerSec)
+	if bl.bucket.tokens > bl.bucket.burst {
+		bl.bucket.tokens = bl.bucket.burst
+	}
+}
+
+// 得到令牌桶容量，不限制时返回0
+func (bl *bandwidthLimiter) burst() int {
+	if bl == nil {
+		return 0
+	}
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	if bl.bucket == nil {
+		return 0
+	}
+	return int(bl.bucket.burst)
+}
+
+// 取出n字节的令牌，令牌不足时等待，ctx被取消时返回错误
+func (bl *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	if bl == nil {
+		return nil
+	}
+	bl.lock.Lock()
+	if bl.bucket == nil {
+		bl.lock.Unlock()
+		return nil
+	}
+	delay := bl.bucket.reserve(time.Now(), float64(n))
+	bl.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrConnClosed
+	}
+}
+
+// 链接及Server的带宽限制器
+type bandwidthLimiters struct {
+	send *bandwidthLimiter
+	recv *bandwidthLimiter
+}
+
+// 可以提供全服带宽限制器的Server
+type bandwidthServer interface {
+	getBandwidthLimiters() *bandwidthLimiters
+}
+
+// 调整链接的带宽限制，每秒发送/接收的字节数，0表示不限制
+func (c *Connection) SetBandwidthLimit(sendBytesPerSec int, recvBytesPerSec int) {
+	c.bandwidth.send.SetRate(sendBytesPerSec)
+	c.bandwidth.recv.SetRate(recvBytesPerSec)
+}
+
+// Writer发送n字节之前等待链接及全服的发送带宽
+func (c *Connection) waitSendBandwidth(n int) error {
+	if err := c.bandwidth.send.wait(c.ctx, n); err != nil {
+		return err
+	}
+	if c.serverBandwidth != nil {
+		return c.serverBandwidth.send.wait(c.ctx, n)
+	}
+	return nil
+}
+
+// Reader收到n字节之后等待链接及全服的接收带宽，等待期间不读取socket
+func (c *Connection) waitRecvBandwidth(n int) error {
+	if err := c.bandwidth.recv.wait(c.ctx, n); err != nil {
+		return err
+	}
+	if c.serverBandwidth != nil {
+		return c.serverBandwidth.recv.wait(c.ctx, n)
+	}
+	return nil
+}
+
+// 调整全服的带宽限制，所有链接合计每秒发送/接收的字节数，0表示不限制
+func (s *Server) SetBandwidthLimit(sendBytesPerSec int, recvBytesPerSec int) {
+	s.bandwidth.send.SetRate(sendBytesPerSec)
+	s.bandwidth.recv.SetRate(recvBytesPerSec)
+}
+
+func (s *Server) getBandwidthLimiters() *bandwidthLimiters {
+	return &s.bandwidth
+}
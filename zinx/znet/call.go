@@ -0,0 +1,101 @@
+package znet
+
+import (
+	"context"
+	"fmt"
+	"src/zinx/utils"
+	"sync/atomic"
+	"time"
+)
+
+// 链接上等待客户端回复的调用
+type pendingCalls struct {
+	lastReqID uint32                 //最后分配的请求ID，通过原子操作递增
+	calls     map[uint32]chan []byte //请求ID -> 接收回复的channel
+}
+
+// 向客户端发送一条请求消息，并等待客户端用相同请求ID回复的消息数据
+// ctx没有设置超时时间时使用 GlobalObject.CallTimeout，等待Writer发送期间同样受超时时间限制
+// 回复由链接的Reader投递，因此不能在Reader中调用：同步路由(AddSyncRouter)中，
+// 以及开启加密或会话恢复时由Reader调用的OnConnStart、OnConnResume中调用会一直等到超时
+func (c *Connection) Call(ctx context.Context, msgId uint32, data []byte) ([]byte, error) {
+	if c.isClosed() {
+		return nil, ErrConnClosed
+	}
+	if _, ok := ctx.Deadline(); !ok && utils.GlobalObject.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(utils.GlobalObject.CallTimeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	//登记等待回复的调用，回复由Reader投递
+	reqID := atomic.AddUint32(&c.calls.lastReqID, 1)
+	replyChan := make(chan []byte, 1)
+	c.callLock.Lock()
+	if c.calls.calls == nil {
+		c.calls.calls = make(map[uint32]chan []byte)
+	}
+	c.calls.calls[reqID] = replyChan
+	c.callLock.Unlock()
+	defer func() {
+		c.callLock.Lock()
+		delete(c.calls.calls, reqID)
+		c.callLock.Unlock()
+	}()
+
+	msg := NewMsgPackage(msgId, data)
+	msg.Flags = MsgFlagRequest
+	msg.ReqID = reqID
+	binaryMsg, err := c.packMsg(msg)
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return nil, err
+	}
+	if err := c.sendRawContext(ctx, binaryMsg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrConnClosed
+	}
+}
+
+// 将客户端的回复投递给等待的调用，没有对应的调用(例如已经超时)时丢弃
+func (c *Connection) handleResponse(req *Request) {
+	defer req.Release()
+
+	c.callLock.Lock()
+	replyChan, ok := c.calls.calls[req.message.ReqID]
+	c.callLock.Unlock()
+	if !ok {
+		fmt.Println("drop response without pending call, ConnID =", c.ConnID, "reqID =", req.message.ReqID)
+		return
+	}
+	//Request的数据缓冲会被回收复用，需要拷贝一份
+	reply := append([]byte(nil), req.GetData()...)
+	select {
+	case replyChan <- reply:
+	default:
+	}
+}
+
+// 回复客户端的请求消息，回复消息带有相同的MsgID及请求ID
+func (c *Connection) sendReply(msgId uint32, reqId uint32, data []byte) error {
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+	msg := NewMsgPackage(msgId, data)
+	msg.Flags = MsgFlagResponse
+	msg.ReqID = reqId
+	binaryMsg, err := c.packMsg(msg)
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return err
+	}
+	return c.sendRaw(binaryMsg)
+}
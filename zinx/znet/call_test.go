@@ -0,0 +1,27 @@
+package znet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Writer一直没有取走消息时，Call在ctx超时后返回，不会一直阻塞在发送上
+func TestCall_sendTimeout(t *testing.T) {
+	c := &Connection{dp: NewDataPack(), msgChan: make(chan []byte)}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Call(ctx, 1, []byte("ping")); err != context.DeadlineExceeded {
+		t.Fatalf("Call err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Call blocked %v", elapsed)
+	}
+	if len(c.calls.calls) != 0 {
+		t.Fatalf("pending calls = %d", len(c.calls.calls))
+	}
+}
@@ -0,0 +1,174 @@
+package znet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"src/zinx/ziface"
+	"sync"
+)
+
+// 内置压缩算法的ID
+const (
+	CompressorGzip    uint8 = 1
+	CompressorDeflate uint8 = 2
+)
+
+var (
+	//消息使用了没有注册的压缩算法
+	ErrUnknownCompressor = errors.New("unknown compressor")
+	//消息解压之后超过了允许的最大长度
+	ErrDecompressTooLarge = errors.New("too large decompressed msg size")
+)
+
+// 已注册的压缩算法
+var (
+	compressors     = make(map[uint8]ziface.ICompressor)
+	compressorNames = make(map[string]ziface.ICompressor)
+	compressorLock  sync.RWMutex
+)
+
+// 注册一个压缩算法，ID或名称重复时会覆盖之前注册的算法
+func RegisterCompressor(compressor ziface.ICompressor) {
+	compressorLock.Lock()
+	defer compressorLock.Unlock()
+	compressors[compressor.ID()] = compressor
+	compressorNames[compressor.Name()] = compressor
+}
+
+// 根据ID获取压缩算法
+func GetCompressor(id uint8) (ziface.ICompressor, bool) {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	compressor, ok := compressors[id]
+	return compressor, ok
+}
+
+// 根据名称获取压缩算法
+func GetCompressorByName(name string) (ziface.ICompressor, bool) {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	compressor, ok := compressorNames[name]
+	return compressor, ok
+}
+
+// 注册内置的压缩算法
+func init() {
+	RegisterCompressor(&GzipCompressor{})
+	RegisterCompressor(&DeflateCompressor{})
+}
+
+// 从r中读取全部解压之后的数据，超过maxLen时返回错误
+func readAllLimited(r io.Reader, maxLen int) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(maxLen)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(maxLen) {
+		return nil, ErrDecompressTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// gzip压缩算法
+type GzipCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (gc *GzipCompressor) ID() uint8 {
+	return CompressorGzip
+}
+
+func (gc *GzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gc *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := gc.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	defer gc.writers.Put(w)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gc *GzipCompressor) Decompress(data []byte, maxLen int) ([]byte, error) {
+	r, ok := gc.readers.Get().(*gzip.Reader)
+	if ok {
+		if err := r.Reset(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	defer gc.readers.Put(r)
+
+	return readAllLimited(r, maxLen)
+}
+
+// deflate压缩算法
+type DeflateCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (dc *DeflateCompressor) ID() uint8 {
+	return CompressorDeflate
+}
+
+func (dc *DeflateCompressor) Name() string {
+	return "deflate"
+}
+
+func (dc *DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := dc.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var err error
+		if w, err = flate.NewWriter(&buf, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	}
+	defer dc.writers.Put(w)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (dc *DeflateCompressor) Decompress(data []byte, maxLen int) ([]byte, error) {
+	r, ok := dc.readers.Get().(io.ReadCloser)
+	if ok {
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(data), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	defer dc.readers.Put(r)
+
+	return readAllLimited(r, maxLen)
+}
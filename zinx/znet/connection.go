@@ -1,13 +1,38 @@
 package znet
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdh"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"src/zinx/utils"
 	"src/zinx/ziface"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 链接的生命周期状态，只能按 Connecting -> Active -> Closing -> Closed 的顺序单向迁移
+const (
+	ConnStateConnecting int32 = iota //链接已建立，读写业务尚未启动
+	ConnStateActive                  //读写业务已启动
+	ConnStateClosing                 //链接正在关闭，不再接受新的发送
+	ConnStateClosed                  //链接已关闭，资源已回收
+)
+
+var (
+	//链接已经关闭(或正在关闭)时发送数据返回的错误
+	ErrConnClosed = errors.New("connection closed")
+	//有缓冲发送队列已满，消息被丢弃
+	ErrSendBuffDropped = errors.New("send buff queue full, msg dropped")
+	//有缓冲发送队列已满，等待超时
+	ErrSendBuffTimeout = errors.New("send buff queue full, wait timeout")
+	//有缓冲发送队列已满，慢速的链接已被断开
+	ErrSendBuffDisconnect = errors.New("send buff queue full, slow connection closed")
 )
 
 // 链接模块
@@ -21,35 +46,168 @@ type Connection struct {
 	//链接的ID
 	ConnID uint32 // 连接的唯一标识符
 
-	//当前的链接状态
-	isClosed bool // 连接是否已关闭
+	//当前的链接状态，取值为ConnStateXxx，只能通过原子操作读写
+	state int32
+	//是否正在优雅关闭，置位后不再读取和接受新的发送，只等待Writer发完队列中的消息
+	draining int32
+	//是否因传输层断开(对端关闭、网络错误)而关闭，只有这种情况才保存会话等待重连
+	transportLost int32
+	//Reader正在同步调用业务代码(同步路由、钩子函数)时为1，此时在其中调用StopGracefully不能等待Reader退出
+	readerCalling int32
+	//0 尚未调用OnConnStart或OnConnResume；1 已经调用；2 已经Stop，不会再调用
+	//没有调用过时Stop也不调用OnConnStop
+	started int32
+	//通知Writer发送完队列中的消息后退出
+	drainChan chan struct{}
+	//Reader、Writer退出时被关闭
+	readerDone chan struct{}
+	writerDone chan struct{}
 
-	//告知当前链接已经退出的/停止channel
-	ExitChan chan bool // 通知连接退出的通道
+	//链接的上下文，链接关闭时被取消，用于通知Writer及业务Goroutine退出
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	//无缓冲的管道，用于读、写Goroutine之间的消息通信(由Reader告知Writer退出)
+	//无缓冲的管道，用于读、写Goroutine之间的消息通信
 	msgChan chan []byte
+	//有缓冲的管道，用于读、写Goroutine之间的消息通信
+	msgBuffChan chan []byte
+	//高、低优先级的有缓冲发送队列，普通优先级使用msgBuffChan
+	highChan chan []byte
+	lowChan  chan []byte
+	//各优先级连续发送的消息数，用于避免低优先级饿死，只在Writer中访问
+	priorityStreak [priorityLevels - 1]int
+	//有缓冲发送队列因队列已满而丢弃的消息数量
+	buffDropCount uint64
+	//链接的流量统计
+	stats connStats
+	//Writer发起的writev调用次数
+	writeBatches uint64
+	//Writer发送的消息帧数
+	writeFrames uint64
 
 	//消息的管理MsgID和对应的处理业务API关系
 	MsgHandler ziface.IMsgHandle
 
-	//链接属性集合
-	property map[string]interface{}
-	//保护链接属性的锁
-	propertyLock sync.RWMutex
+	//带缓冲的读取器，减少读取消息时的系统调用次数
+	reader *bufio.Reader
+	//经过过滤链之后的读取端及写入端，分别只在Reader、Writer中使用
+	//Writer启动之前由SetFilters在startLock保护下切换写入端
+	frameReader io.Reader
+	frameWriter io.Writer
+	//保护Start的状态切换，保证Writer启动之前SetFilters对写入端的修改已经完成
+	startLock sync.Mutex
+	//等待Reader切换的读取端过滤链
+	readFilters atomic.Pointer[[]ziface.IFilter]
+	//通知Writer切换写入端的过滤链
+	filterChan chan []ziface.IFilter
+	//拆包解包对象，整个链接复用一个
+	dp *DataPack
+	//读取消息head的缓冲，整个链接复用一个
+	headBuf []byte
+
+	//链接的会话存储，链接属性都保存在这里，恢复会话时会被替换
+	session     ziface.ISession
+	sessionLock sync.RWMutex
+	//会话恢复令牌，未开启会话恢复时为空
+	resumeToken string
+	//可靠消息通道
+	reliable reliableChannel
+	//等待客户端回复的调用
+	calls    pendingCalls
+	callLock sync.Mutex
+
+	//收到消息的速率限制，只在Reader中访问
+	connLimiter *tokenBucket
+	msgLimiters map[uint32]*tokenBucket
+	//收到的消息超过速率限制的次数
+	rateViolations uint32
+	//最近一段时间内连续超限的次数及最后一次超限的时间，安静超过RateLimitViolationWindow后清零，只在Reader中访问
+	recentViolations uint32
+	lastViolation    time.Time
+
+	//正在处理中的消息占用的名额，容量为MaxInFlightPerConn，为nil时不限制
+	inFlight chan struct{}
+	//Reader因处理中的消息达到上限而暂停的次数及累计时长(纳秒)
+	readPauses     uint64
+	readPausedTime int64
+
+	//链接的带宽限制，以及所属Server的全服带宽限制
+	bandwidth       bandwidthLimiters
+	serverBandwidth *bandwidthLimiters
+
+	//是否被判定为慢速消费者，只能通过原子操作读写
+	slow int32
+	//被判定为慢速消费者的次数，慢速状态期间丢弃的非关键消息数
+	slowCount     uint64
+	slowDropCount uint64
+	//Writer正在进行的写入的开始时间(UnixNano)，没有写入时为0，只能通过原子操作读写
+	writeStart int64
+	//写入阻塞过久时检查慢速消费者的看门狗，只在Writer中使用
+	writeWatchdog *time.Timer
+	//阻塞在无缓冲msgChan上等待发送的调用者数量，计入发送积压
+	msgChanWaiters int32
+
+	//是否开启了加密，开启后密钥交换完成之前不会发出任何其他消息
+	encrypted bool
+	//密钥交换使用的临时密钥，未开启加密时为nil
+	handshakeKey *ecdh.PrivateKey
+	//解密客户端消息的对象，只在Reader中使用
+	recvCipher *FrameCipher
+	//加密发给客户端消息的对象，只在Writer中使用
+	sendCipher atomic.Pointer[FrameCipher]
 }
 
 // 初始化链接模块的方法
 func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgHandler ziface.IMsgHandle) *Connection {
 	c := &Connection{
-		TcpServer:  server,
-		Conn:       conn,
-		ConnID:     connID,
-		MsgHandler: msgHandler,
-		isClosed:   false,
-		msgChan:    make(chan []byte),
-		ExitChan:   make(chan bool, 1),
-		property:   make(map[string]interface{}),
+		TcpServer:   server,
+		Conn:        conn,
+		ConnID:      connID,
+		MsgHandler:  msgHandler,
+		state:       ConnStateConnecting,
+		msgChan:     make(chan []byte),
+		msgBuffChan: make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
+		highChan:    make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
+		lowChan:     make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
+		drainChan:   make(chan struct{}),
+		readerDone:  make(chan struct{}),
+		writerDone:  make(chan struct{}),
+		session:     NewSession(),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.stats.connectTime = time.Now()
+	c.reader = bufio.NewReaderSize(conn, int(utils.GlobalObject.ReadBufferSize))
+	//使用Server配置的过滤链
+	c.frameReader = buildFilterReader(c.reader, server.GetFilters())
+	c.frameWriter = buildFilterWriter(conn, server.GetFilters())
+	c.filterChan = make(chan []ziface.IFilter)
+	c.dp = NewDataPack()
+	c.headBuf = make([]byte, c.dp.GetHeadLen())
+	c.bandwidth.send = newBandwidthLimiter(utils.GlobalObject.MaxSendBytesPerSec)
+	c.bandwidth.recv = newBandwidthLimiter(utils.GlobalObject.MaxRecvBytesPerSec)
+	if s, ok := server.(bandwidthServer); ok {
+		c.serverBandwidth = s.getBandwidthLimiters()
+	}
+	if n := utils.GlobalObject.MaxInFlightPerConn; n > 0 {
+		c.inFlight = make(chan struct{}, n)
+	}
+	//开启了加密，为链接生成密钥交换使用的临时密钥
+	//生成失败时handshakeKey为nil，Start时会直接关闭链接，不会退化为明文通信
+	if utils.GlobalObject.EnableEncryption {
+		c.encrypted = true
+		key, err := newHandshakeKey()
+		if err != nil {
+			fmt.Println("new handshake key err:", err)
+		}
+		c.handshakeKey = key
+	}
+	//开启了会话恢复，为链接生成令牌
+	if resumeMgr := server.GetResumeMgr(); resumeMgr != nil {
+		token, err := resumeMgr.NewToken()
+		if err != nil {
+			fmt.Println("new resume token err:", err)
+		}
+		c.resumeToken = token
 	}
 	//将conn加入到ConnManager中
 	c.TcpServer.GetConnMgr().Add(c)
@@ -60,119 +218,604 @@ func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, msgH
 func (c *Connection) StartReader() {
 	fmt.Println("[Reader Goroutine is running]")
 	defer fmt.Println("[Reader is exit],connID =", c.ConnID, "remote addr is ", c.Conn.RemoteAddr().String())
-	defer c.Stop()
+	defer close(c.readerDone)
+	defer func() {
+		//优雅关闭时由StopGracefully负责最终的Stop
+		if atomic.LoadInt32(&c.draining) == 0 {
+			c.Stop()
+		}
+	}()
 
+	//开启加密时，客户端的第一条消息必须是密钥交换
+	waitHandshake := c.encrypted
+	//开启会话恢复时，要等到客户端的第一条消息才能确定是新链接还是重连
+	waitFirst := c.resumeToken != ""
 	for {
-		//读取客户端的数据到buf中
-		//buf := make([]byte, utils.GlobalObject.MaxPacketSize)
-		//_, err := c.Conn.Read(buf)
-		//if err != nil {
-		//	fmt.Println("recv buf err", err)
-		//	continue
-		//}
-		//创建一个拆包解包对象
-		dp := NewDataPack()
-
-		//读取客户端的Msg Head 二级制流 8个字节
-		headData := make([]byte, dp.GetHeadLen())
-		if _, err := io.ReadFull(c.GetTCPConnection(), headData); err != nil {
-			fmt.Println("read msg head err:", err)
+		//在消息边界上切换读取端的过滤链
+		c.applyReadFilters()
+
+		//读取一个完整的消息，得到当前conn数据的Request请求数据
+		req, err := c.readRequest()
+		if err != nil {
+			fmt.Println("read msg err:", err)
+			c.markTransportLost(err)
 			break
 		}
 
-		//拆包，得到msgID 和msgDatalen 放在 msg 消息中
-		msg, err := dp.UnPack(headData)
-		if err != nil {
-			fmt.Println("unpack msg err:", err)
-		}
-		//dataLen 再次读取Data， 放在msg.Data中
-		var data []byte
-		if msg.GetMsgLen() > 0 {
-			data = make([]byte, msg.GetMsgLen())
-			if _, err := io.ReadFull(c.GetTCPConnection(), data); err != nil {
-				fmt.Println("read msg data err:", err)
+		if waitHandshake {
+			waitHandshake = false
+			if err := c.handleHandshake(req); err != nil {
+				fmt.Println("handshake err:", err)
 				break
 			}
+			atomic.StoreInt32(&c.readerCalling, 1)
+			c.startSession()
+			atomic.StoreInt32(&c.readerCalling, 0)
+			continue
 		}
-		msg.SetData(data)
 
-		//得到当前conn数据的Request请求数据
-		req := Request{
-			conn: c,
-			msg:  msg,
+		if waitFirst {
+			waitFirst = false
+			atomic.StoreInt32(&c.readerCalling, 1)
+			handled := c.handleFirstRequest(req)
+			atomic.StoreInt32(&c.readerCalling, 0)
+			if handled {
+				continue
+			}
 		}
 
-		if utils.GlobalObject.WorkerPoolSize > 0 {
-			//已经开启了工作池机制，将消息发送给Worker工作池处理即可
-			c.MsgHandler.SendMsgToTaskQueue(&req)
-		} else {
-			//从路由中，找到注册绑定的Conn对应的router调用
-			//根据绑定号的MsgID 找到对应处理api 业务 执行
-			go c.MsgHandler.DoMsgHandler(&req)
+		//超过速率限制的消息按RateLimitPolicy处理，不交给路由
+		//可靠消息的确认和Call的回复同样受限制，避免客户端借此不受限制地发送
+		if !c.allowMsg(req.GetMsgID()) {
+			atomic.StoreInt32(&c.readerCalling, 1)
+			ok := c.handleRateLimited(req)
+			atomic.StoreInt32(&c.readerCalling, 0)
+			if !ok {
+				break
+			}
+			continue
+		}
+
+		//可靠消息的确认由框架处理，不交给路由
+		if req.GetMsgID() == MsgIDAck {
+			c.handleAck(req)
+			continue
+		}
+		//客户端对Call的回复交给等待的调用，不交给路由
+		if req.message.Flags&MsgFlagResponse != 0 {
+			c.handleResponse(req)
+			continue
 		}
 
+		if c.MsgHandler.IsSyncRouter(req.GetMsgID()) {
+			//同步路由在Reader中直接处理，处理完之前不读取下一条消息
+			atomic.StoreInt32(&c.readerCalling, 1)
+			c.MsgHandler.DoMsgHandler(req)
+			atomic.StoreInt32(&c.readerCalling, 0)
+			continue
+		}
+		//占用处理中名额，名额用完时在这里暂停读取
+		if !c.acquireInFlight(req) {
+			req.Release()
+			break
+		}
+		//交给Worker工作池处理，没有开启工作池时由MsgHandle开启Goroutine处理
+		//Worker队列已满或Goroutine数量达到上限时阻塞，同样会暂停读取
+		c.MsgHandler.SendMsgToTaskQueue(req)
+
 	}
 }
 
+// 从链接中读取一个完整的消息，封装成Request
+// Request及其数据缓冲都来自缓冲池，路由处理完毕后由MsgHandle调用Release归还
+func (c *Connection) readRequest() (*Request, error) {
+	//读取客户端的Msg Head 二级制流 8个字节
+	if _, err := io.ReadFull(c.frameReader, c.headBuf); err != nil {
+		return nil, err
+	}
+
+	//拆包，得到msgID 和msgDatalen 放在 msg 消息中
+	req := getRequest(c)
+	if err := c.dp.UnPackTo(c.headBuf, &req.message); err != nil {
+		req.Release()
+		return nil, err
+	}
+
+	//dataLen 再次读取Data， 放在msg.Data中
+	dataLen := int(req.message.DataLen)
+	if dataLen > 0 {
+		req.buf = getBuffer(dataLen)
+		if _, err := io.ReadFull(c.frameReader, *req.buf); err != nil {
+			req.Release()
+			return nil, err
+		}
+		req.message.Data = *req.buf
+	}
+	//开启加密之后，所有消息都需要先解密
+	if c.recvCipher != nil {
+		if err := c.recvCipher.OpenMsg(&req.message); err != nil {
+			req.Release()
+			return nil, err
+		}
+	}
+	//解析扩展头
+	if err := c.dp.UnPackExt(&req.message); err != nil {
+		req.Release()
+		return nil, err
+	}
+	c.stats.recordRead(req.message.Id, len(c.headBuf)+dataLen)
+	//按带宽限制等待，等待期间不再读取socket
+	if err := c.waitRecvBandwidth(len(c.headBuf) + dataLen); err != nil {
+		req.Release()
+		return nil, err
+	}
+	return req, nil
+}
+
 // 写消息Goroutine，专门发送给客户端消息的模块
+// 每次被唤醒时会把队列中已有的消息一并取出，合并成一次writev系统调用发送
 func (c *Connection) StartWriter() {
 	fmt.Println("【Writer Goroutine is running]")
 	defer fmt.Println("[conn Writer exit!]", c.RemoteAddr().String())
+	defer close(c.writerDone)
+
+	latency := time.Duration(utils.GlobalObject.WriteBatchLatency) * time.Microsecond
+	batch := make(net.Buffers, 0, 64)
 	//不断的阻塞的等待channel的消息，进行写给客户端
 	for {
-		select {
-		case data := <-c.msgChan:
-			//有数据要写给客户端
-			if _, err := c.Conn.Write(data); err != nil {
-				fmt.Println("Send data err:", err)
+		//按优先级取出下一条消息，所有队列都为空时阻塞等待
+		data, ok := c.tryNextFrame()
+		if !ok {
+			level := 0
+			select {
+			case data = <-c.highChan:
+			case data = <-c.msgChan:
+				level = 1
+			case data = <-c.msgBuffChan:
+				level = 1
+			case data = <-c.lowChan:
+				level = 2
+			case filters := <-c.filterChan:
+				//切换写入端的过滤链
+				c.frameWriter = buildFilterWriter(c.Conn, filters)
+				continue
+			case <-c.drainChan:
+				//链接正在优雅关闭，发送完队列中已有的消息后退出
+				if err := c.flushQueued(batch); err != nil {
+					fmt.Println("Send data err:", err)
+				}
+				return
+			case <-c.ctx.Done():
+				//代表链接已经关闭，此时Writer也要退出
 				return
 			}
-		case <-c.ExitChan:
-			//代表Reader已经退出，此时Writer也要退出
+			c.servedPriority(level)
+		}
+
+		//有数据要写给客户端，先尽量多攒一些再统一发送
+		batch = c.collectBatch(append(batch, data), len(data), latency)
+		if err := c.flushBatch(batch); err != nil {
+			fmt.Println("Send data err:", err)
+			c.markTransportLost(err)
+			c.Stop()
 			return
+		}
+		//清空引用，便于发送完的数据被回收
+		clear(batch)
+		batch = batch[:0]
+	}
+}
 
+// 从发送队列中继续取出已排队的消息追加到batch中
+// 直到总字节数达到 MaxWriteBatchBytes，或队列为空且等待超过latency
+func (c *Connection) collectBatch(batch net.Buffers, size int, latency time.Duration) net.Buffers {
+	maxBytes := int(utils.GlobalObject.MaxWriteBatchBytes)
+	//开启了带宽限制时，一次合并发送不超过令牌桶容量，避免攒成大块突发
+	if burst := c.bandwidth.send.burst(); burst > 0 && burst < maxBytes {
+		maxBytes = burst
+	}
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
 		}
+	}()
+
+	for size < maxBytes {
+		data, ok := c.tryNextFrame()
+		if !ok {
+			//队列已空，不等待则立即发送
+			if latency <= 0 {
+				return batch
+			}
+			if timer == nil {
+				timer = time.NewTimer(latency)
+			}
+			level := 0
+			select {
+			case data = <-c.highChan:
+			case data = <-c.msgChan:
+				level = 1
+			case data = <-c.msgBuffChan:
+				level = 1
+			case data = <-c.lowChan:
+				level = 2
+			case <-timer.C:
+				return batch
+			case <-c.ctx.Done():
+				return batch
+			}
+			c.servedPriority(level)
+		}
+		batch = append(batch, data)
+		size += len(data)
+	}
+	return batch
+}
+
+// 将batch中的所有消息通过一次writev写给客户端，并更新写合并的统计
+func (c *Connection) flushBatch(batch net.Buffers) error {
+	//开启了加密，发送之前逐条加密
+	for i := range batch {
+		sealed, err := c.sealFrame(batch[i])
+		if err != nil {
+			return err
+		}
+		batch[i] = sealed
+	}
+
+	//按带宽限制等待，等待时间不计入写入耗时
+	size := 0
+	for _, frame := range batch {
+		size += len(frame)
+	}
+	if err := c.waitSendBandwidth(size); err != nil {
+		return err
+	}
+
+	//客户端长时间不读取时，写超时会让Writer返回错误并断开链接
+	start := time.Now()
+	if timeout := utils.GlobalObject.SlowConsumerWriteTimeout; timeout > 0 {
+		c.Conn.SetWriteDeadline(start.Add(time.Duration(timeout) * time.Millisecond))
+	}
+
+	//WriteTo会修改切片本身，使用副本发送，batch留给调用者复用
+	c.beginWrite(start)
+	bufs := batch
+	n, err := bufs.WriteTo(c.frameWriter)
+	c.stats.recordWrite(n)
+	//过滤链中有需要刷新的Writer
+	if f, ok := c.frameWriter.(flusher); ok && err == nil {
+		err = f.Flush()
+	}
+	c.endWrite()
+	if err != nil {
+		//写超时说明客户端一直不读取，先按慢速消费者上报再断开
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			c.checkSlowConsumer(c.sendQueueLen(), time.Since(start))
+		}
+		return err
+	}
+	atomic.AddUint64(&c.writeBatches, 1)
+	atomic.AddUint64(&c.writeFrames, uint64(len(batch)))
+
+	//根据写入耗时和剩余的积压检查慢速消费者
+	latency := time.Since(start)
+	queueLen := c.sendQueueLen()
+	if c.checkSlowConsumer(queueLen, latency) {
+		return ErrConnClosed
+	}
+	c.recoverSlowConsumer(queueLen, latency)
+	return nil
+}
+
+// 将发送队列中已有的消息全部发送出去，不再等待新的消息
+func (c *Connection) flushQueued(batch net.Buffers) error {
+	for {
+		data, ok := c.tryNextFrame()
+		if !ok {
+			return nil
+		}
+		batch = c.collectBatch(append(batch[:0], data), len(data), 0)
+		if err := c.flushBatch(batch); err != nil {
+			return err
+		}
+		clear(batch)
 	}
 }
 
+// 获取写合并的统计：writev调用次数，以及发送的消息帧数
+// frames/batches 即为平均每次系统调用合并的消息数
+func (c *Connection) GetWriteBatchStats() (batches uint64, frames uint64) {
+	return atomic.LoadUint64(&c.writeBatches), atomic.LoadUint64(&c.writeFrames)
+}
+
 // 启动连接
 func (c *Connection) Start() {
 	fmt.Println("Conn Start() ... ConnID:", c.ConnID)
+	//只有处于Connecting状态的链接才能启动，防止重复启动或启动已关闭的链接
+	c.startLock.Lock()
+	started := atomic.CompareAndSwapInt32(&c.state, ConnStateConnecting, ConnStateActive)
+	c.startLock.Unlock()
+	if !started {
+		return
+	}
+	//开启了加密却没有临时密钥，不能退化为明文通信，直接关闭
+	if c.encrypted && c.handshakeKey == nil {
+		fmt.Println("no handshake key, stop ConnID =", c.ConnID)
+		c.Stop()
+		return
+	}
+
 	//启动从当前链接的读数据的业务
 	go c.StartReader()
 	// 启动当前链接写数据的业务
 	go c.StartWriter()
 
+	//开启了加密，先进行密钥交换，交换完成之后再开始会话
+	if c.encrypted {
+		if err := c.startHandshake(); err != nil {
+			fmt.Println("start handshake err:", err)
+			c.Stop()
+		}
+		return
+	}
+	c.startSession()
+}
+
+// 开始链接的会话，执行OnConnStart钩子函数
+func (c *Connection) startSession() {
+	//开启了会话恢复，先下发令牌，OnConnStart或OnConnResume在收到客户端第一条消息时再调用
+	//客户端在SessionResumeWait内没有发来任何消息(例如只接收推送的客户端)时按新链接调用OnConnStart
+	if c.resumeToken != "" {
+		if err := c.SendBuffMsg(MsgIDResumeToken, []byte(c.resumeToken)); err != nil {
+			fmt.Println("send resume token err:", err)
+		}
+		c.AfterFunc(time.Duration(utils.GlobalObject.SessionResumeWait)*time.Millisecond, func() {
+			if !c.isClosed() {
+				c.callOnConnStart()
+			}
+		})
+		return
+	}
+
 	//按照开发者传递进来的 创建链接之后需要调用的处理业务，执行对应hook函数
-	c.TcpServer.CallOnConnStart(c)
+	c.callOnConnStart()
 
 }
 
+// 调用OnConnStart钩子函数，每个链接的OnConnStart、OnConnResume只会调用其中一个，并且只调用一次
+func (c *Connection) callOnConnStart() {
+	if atomic.CompareAndSwapInt32(&c.started, 0, 1) {
+		c.TcpServer.CallOnConnStart(c)
+	}
+}
+
+// 处理客户端的第一条消息，是恢复会话的请求时返回true，该消息不再交给路由处理
+func (c *Connection) handleFirstRequest(req *Request) bool {
+	if req.GetMsgID() != MsgIDResume {
+		//新的链接
+		c.callOnConnStart()
+		return false
+	}
+	token := string(req.GetData())
+	req.Release()
+
+	//等待超时之后已经按新链接调用了OnConnStart，不能再恢复会话
+	if !atomic.CompareAndSwapInt32(&c.started, 0, 1) {
+		fmt.Println("resume request after SessionResumeWait, ignored, ConnID =", c.ConnID)
+		return true
+	}
+	resumed, ok := c.TcpServer.GetResumeMgr().Resume(token)
+	if !ok {
+		//令牌无效或已过期，当作新的链接处理
+		fmt.Println("resume session failed, ConnID =", c.ConnID)
+		c.TcpServer.CallOnConnStart(c)
+		return true
+	}
+
+	//接管原来的会话，并把断开时没发出去的消息重新放入发送队列
+	c.sessionLock.Lock()
+	c.session = resumed.Session
+	c.sessionLock.Unlock()
+	c.restoreReliable(resumed.LastSeq, resumed.Unacked)
+	for _, data := range resumed.Pending {
+		if err := c.sendBuffRaw(data); err != nil {
+			fmt.Println("resend pending msg err:", err)
+			break
+		}
+	}
+	fmt.Println("session resumed, ConnID =", c.ConnID, "pending msg num =", len(resumed.Pending))
+	c.TcpServer.CallOnConnResume(c)
+	return true
+}
+
+// 链接断开时保存会话及未发送的消息，等待客户端重连
+func (c *Connection) parkSession() {
+	var pending [][]byte
+	//按优先级顺序保存各队列中的消息
+	for _, queue := range []chan []byte{c.highChan, c.msgBuffChan, c.lowChan} {
+	drain:
+		for {
+			select {
+			case data := <-queue:
+				pending = append(pending, data)
+			default:
+				break drain
+			}
+		}
+	}
+	lastSeq, unacked := c.reliable.save()
+	c.TcpServer.GetResumeMgr().Park(c.resumeToken, ziface.ResumableSession{
+		Session: c.GetSession(),
+		Pending: pending,
+		LastSeq: lastSeq,
+		Unacked: unacked,
+	})
+}
+
+// Reader、Writer读写出错时记录是否为传输层断开，需要在Stop之前调用
+// 读写超时是服务器自己设置的截止时间(优雅关闭、慢速消费者)，不算传输层断开
+func (c *Connection) markTransportLost(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		atomic.StoreInt32(&c.transportLost, 1)
+		return
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, net.ErrClosed) {
+		atomic.StoreInt32(&c.transportLost, 1)
+	}
+}
+
 // 停止链接 结束当前链接的工作
 func (c *Connection) Stop() {
 	fmt.Println("Conn Stop.. ConnID: ", c.ConnID)
 
-	//如果当前链接已经关闭
-	if c.isClosed == true {
+	//只有第一个成功迁移到Closing状态的调用者负责关闭，其余直接返回
+	if !c.beginClose() {
 		return
 	}
-	c.isClosed = true
 
 	//调用开发者注册的 销毁链接之前 需要执行的业务hook函数
-	c.TcpServer.CallOnConnStop(c)
+	//没有调用过OnConnStart或OnConnResume(例如密钥交换或等待恢复请求期间断开)时不调用，保证钩子成对出现
+	if atomic.SwapInt32(&c.started, 2) == 1 {
+		c.TcpServer.CallOnConnStop(c)
+	}
+
+	//取消链接的上下文，告知Writer及所有等待发送的Goroutine退出
+	c.cancel()
 
 	//关闭socket链接
 	c.Conn.Close()
 
-	//告知Write关闭
-	c.ExitChan <- true
+	//开启了会话恢复并且是传输层断开，保存会话等待客户端重连
+	//服务器主动踢掉的链接(重复登录、限流、慢速消费者、CloseWithMessage等)不保存
+	if c.resumeToken != "" && atomic.LoadInt32(&c.transportLost) == 1 {
+		c.parkSession()
+	}
+
+	//退出加入的所有分组，取消所有订阅及定时器
+	c.TcpServer.GetGroupMgr().LeaveAll(c.ConnID)
+	c.TcpServer.GetPubSub().UnsubscribeAll(c.ConnID)
+	c.TcpServer.GetTimerWheel().CancelConn(c.ConnID)
 
 	//将当前链接从ConnMgr中摘除掉
 	c.TcpServer.GetConnMgr().Remove(c)
 
-	//回收资源
-	close(c.ExitChan)
-	close(c.msgChan)
+	atomic.StoreInt32(&c.state, ConnStateClosed)
+}
+
+// 优雅地停止链接：停止读取，等待Writer发送完队列中已有的消息，半关闭写端后再结束链接
+// 超过timeout仍未完成时直接结束链接
+func (c *Connection) StopGracefully(timeout time.Duration) {
+	//只有已启动的链接需要等待Writer，并且只能优雅关闭一次
+	if atomic.LoadInt32(&c.state) != ConnStateActive || !atomic.CompareAndSwapInt32(&c.draining, 0, 1) {
+		c.Stop()
+		return
+	}
+	fmt.Println("Conn StopGracefully.. ConnID: ", c.ConnID)
+	deadline := time.Now().Add(timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	//1 停止读取，Reader会因读超时退出
+	c.Conn.SetReadDeadline(time.Now())
+
+	//2 通知Writer发送完队列中已有的消息，并等待它退出
+	close(c.drainChan)
+	select {
+	case <-c.writerDone:
+	case <-timer.C:
+		fmt.Println("StopGracefully flush timeout, ConnID =", c.ConnID)
+		c.Stop()
+		return
+	}
+
+	//3 半关闭写端，并丢弃客户端后续发来的数据直到对端关闭
+	//socket接收缓冲中还有未读数据时直接Close会发送RST，导致对端丢弃尚未读取的消息
+	//在Reader中(同步路由、钩子函数)调用时Reader要等到返回之后才会退出，不能等待，直接关闭
+	if utils.GlobalObject.GracefulCloseWrite && atomic.LoadInt32(&c.readerCalling) == 0 {
+		if err := c.Conn.CloseWrite(); err == nil {
+			select {
+			case <-c.readerDone:
+				c.Conn.SetReadDeadline(deadline)
+				io.Copy(io.Discard, c.reader)
+			case <-timer.C:
+			}
+		}
+	}
+
+	c.Stop()
+}
+
+// 发送一条消息之后优雅地关闭链接，保证该消息及之前已在队列中的消息都能送达客户端
+// 例如在踢人之前下发被踢的原因，消息无法封包或发送时直接关闭链接
+func (c *Connection) CloseWithMessage(msgId uint32, data []byte) error {
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+	timeout := time.Duration(utils.GlobalObject.GracefulCloseTimeout) * time.Millisecond
+
+	//消息无法发送时仍然要关闭链接，例如被踢下线的链接不能因此继续留着
+	binaryMsg, err := c.packMsg(NewMsgPackage(msgId, data))
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		c.Stop()
+		return err
+	}
+	if err := c.checkHandshakeDone(binaryMsg); err != nil {
+		c.Stop()
+		return err
+	}
+
+	//这条消息不受队列满策略的影响，一直等到放入队列或超时
+	timer := time.NewTimer(timeout)
+	select {
+	case c.msgBuffChan <- binaryMsg:
+	case <-c.ctx.Done():
+		timer.Stop()
+		return ErrConnClosed
+	case <-timer.C:
+		err = ErrSendBuffTimeout
+	}
+	timer.Stop()
+
+	c.StopGracefully(timeout)
+	return err
+}
+
+// 将链接从Connecting或Active状态迁移到Closing状态，成功返回true
+func (c *Connection) beginClose() bool {
+	for {
+		state := atomic.LoadInt32(&c.state)
+		if state >= ConnStateClosing {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.state, state, ConnStateClosing) {
+			return true
+		}
+	}
+}
+
+// 判断链接是否已经关闭(或正在关闭)，正在优雅关闭的链接也不再接受新的发送
+func (c *Connection) isClosed() bool {
+	return atomic.LoadInt32(&c.state) >= ConnStateClosing || atomic.LoadInt32(&c.draining) == 1
+}
+
+// 获取链接当前的生命周期状态
+func (c *Connection) GetState() int32 {
+	return atomic.LoadInt32(&c.state)
+}
+
+// 获取链接的上下文，链接关闭时被取消
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// 返回一个在链接关闭时被关闭的channel
+func (c *Connection) Done() <-chan struct{} {
+	return c.ctx.Done()
 }
 
 // 获取当前链接绑定的socket conn
@@ -193,49 +836,214 @@ func (c *Connection) RemoteAddr() net.Addr {
 // 发送数据 将数据发送给远程的客户端
 // 提供一个SendMsg方法 将我们要发送给客户端的数据，先进行封包，再发送
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
-	if c.isClosed == true {
-		return errors.New("connection  closed when send msg")
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+	if c.dropIfSlow(msgId) {
+		return ErrSlowConsumerDropped
 	}
 	//将data进行封包MsgDataLen/MsgID/Data
-	dp := NewDataPack()
-	//MsgDataLen/MsgID/Data
-	binaryMsg, err := dp.Pack(NewMsgPackage(msgId, data))
+	binaryMsg, err := c.packMsg(NewMsgPackage(msgId, data))
 	if err != nil {
-		fmt.Println("pack error msg id=:", msgId)
-		return errors.New("pack err msg ")
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return err
 	}
 
 	//将数据发送回客户端
-	c.msgChan <- binaryMsg
+	return c.sendRaw(binaryMsg)
+}
 
-	return nil
+// 封包要发送给客户端的消息，数据达到 CompressThreshold 时按配置的压缩算法压缩
+func (c *Connection) packMsg(msg *Message) ([]byte, error) {
+	compressMsg(msg)
+	return c.dp.Pack(msg)
+}
+
+// 按照配置为超过阈值的消息设置压缩标志，实际压缩在封包时进行
+func compressMsg(msg *Message) {
+	if name := utils.GlobalObject.Compression; name != "" && msg.DataLen >= utils.GlobalObject.CompressThreshold {
+		if compressor, ok := GetCompressorByName(name); ok {
+			msg.Flags |= MsgFlagCompressed
+			msg.Compressor = compressor.ID()
+		}
+	}
+}
+
+// 将已经封包的数据交给Writer发送，链接关闭时不再阻塞等待Writer
+func (c *Connection) sendRaw(binaryMsg []byte) error {
+	return c.sendRawContext(context.Background(), binaryMsg)
+}
+
+// 将已经封包的数据交给Writer发送，链接关闭或ctx结束时不再阻塞等待Writer
+func (c *Connection) sendRawContext(ctx context.Context, binaryMsg []byte) error {
+	if err := c.checkHandshakeDone(binaryMsg); err != nil {
+		return err
+	}
+	select {
+	case c.msgChan <- binaryMsg:
+		return nil
+	default:
+	}
+
+	//Writer正忙，阻塞等待期间计入发送积压
+	atomic.AddInt32(&c.msgChanWaiters, 1)
+	defer atomic.AddInt32(&c.msgChanWaiters, -1)
+	if c.checkSlowConsumer(c.sendQueueLen(), c.writeInProgress()) {
+		return ErrConnClosed
+	}
+	select {
+	case c.msgChan <- binaryMsg:
+		return nil
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 发送数据 将数据封包后放入有缓冲的发送队列，由Writer异步发送给远程的客户端
+// 队列已满时按照 GlobalObject.SendBuffFullPolicy 的策略处理
+func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+	//将data进行封包MsgDataLen/MsgID/Data
+	binaryMsg, err := c.packMsg(NewMsgPackage(msgId, data))
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return err
+	}
+	return c.sendBuffRaw(binaryMsg)
+}
+
+// 将已经封包的数据放入有缓冲的发送队列
+// 队列已满时按照 GlobalObject.SendBuffFullPolicy 的策略处理
+func (c *Connection) sendBuffRaw(binaryMsg []byte) error {
+	if c.dropIfSlow(frameMsgID(binaryMsg)) {
+		return ErrSlowConsumerDropped
+	}
+	return c.sendQueued(c.msgBuffChan, binaryMsg)
+}
+
+// 将已经封包的数据放入有缓冲的发送队列，队列已满时不等待，直接丢弃并计数
+// 供广播使用，避免一个成员的队列已满阻塞对整个分组的发送
+func (c *Connection) trySendBuffRaw(binaryMsg []byte) error {
+	if c.dropIfSlow(frameMsgID(binaryMsg)) {
+		return ErrSlowConsumerDropped
+	}
+	if err := c.checkHandshakeDone(binaryMsg); err != nil {
+		return err
+	}
+	select {
+	case c.msgBuffChan <- binaryMsg:
+		if c.checkSlowConsumer(c.sendQueueLen(), c.writeInProgress()) {
+			return ErrConnClosed
+		}
+		return nil
+	default:
+		atomic.AddUint64(&c.buffDropCount, 1)
+		return ErrSendBuffDropped
+	}
+}
+
+// 将已封包的消息放入指定的有缓冲发送队列，队列已满时按SendBuffFullPolicy处理
+func (c *Connection) sendQueued(queue chan []byte, binaryMsg []byte) error {
+	if err := c.checkHandshakeDone(binaryMsg); err != nil {
+		return err
+	}
+
+	//队列未满 直接放入队列，积压过多时判定为慢速消费者
+	select {
+	case queue <- binaryMsg:
+		if c.checkSlowConsumer(c.sendQueueLen(), c.writeInProgress()) {
+			return ErrConnClosed
+		}
+		return nil
+	default:
+	}
+
+	//队列已满 按照配置的策略处理
+	policy := utils.GlobalObject.SendBuffFullPolicy
+	//无缓冲队列中没有可以丢弃的消息，按drop_newest处理
+	if policy == utils.SendBuffFullDropOldest && cap(queue) == 0 {
+		policy = utils.SendBuffFullDropNewest
+	}
+	switch policy {
+	case utils.SendBuffFullDropNewest:
+		atomic.AddUint64(&c.buffDropCount, 1)
+		return ErrSendBuffDropped
+	case utils.SendBuffFullDropOldest:
+		for {
+			//丢弃一条最早的消息
+			select {
+			case <-queue:
+				atomic.AddUint64(&c.buffDropCount, 1)
+			case <-c.ctx.Done():
+				return ErrConnClosed
+			default:
+			}
+			//再次尝试放入队列，失败说明又被其他发送者抢占了空位
+			select {
+			case queue <- binaryMsg:
+				return nil
+			case <-c.ctx.Done():
+				return ErrConnClosed
+			default:
+			}
+		}
+	case utils.SendBuffFullDisconnect:
+		atomic.AddUint64(&c.buffDropCount, 1)
+		fmt.Println("send buff queue full, stop slow connection ConnID =", c.ConnID)
+		//发送者可能持有Stop需要的锁，在新的Goroutine中关闭
+		go c.Stop()
+		return ErrSendBuffDisconnect
+	default:
+		//block 阻塞等待队列空位
+		if utils.GlobalObject.SendBuffTimeout <= 0 {
+			select {
+			case queue <- binaryMsg:
+				return nil
+			case <-c.ctx.Done():
+				return ErrConnClosed
+			}
+		}
+		timer := time.NewTimer(time.Duration(utils.GlobalObject.SendBuffTimeout) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case queue <- binaryMsg:
+			return nil
+		case <-c.ctx.Done():
+			return ErrConnClosed
+		case <-timer.C:
+			atomic.AddUint64(&c.buffDropCount, 1)
+			return ErrSendBuffTimeout
+		}
+	}
+}
+
+// 获取有缓冲发送队列因队列已满而丢弃的消息数量
+func (c *Connection) GetBuffDropCount() uint64 {
+	return atomic.LoadUint64(&c.buffDropCount)
+}
+
+// 获取链接的会话存储
+func (c *Connection) GetSession() ziface.ISession {
+	c.sessionLock.RLock()
+	defer c.sessionLock.RUnlock()
+	return c.session
 }
 
 // 设置链接属性
 func (c *Connection) SetProperty(key string, value interface{}) {
-	c.propertyLock.Lock()
-	defer c.propertyLock.Unlock()
-	//添加一个链接属性
-	c.property[key] = value
+	c.GetSession().Set(key, value)
 }
 
 // 获取链接属性
 func (c *Connection) GetProperty(key string) (interface{}, error) {
-	c.propertyLock.RLock()
-	defer c.propertyLock.RUnlock()
-	//读取属性
-	if value, ok := c.property[key]; ok {
-		return value, nil
-	} else {
-		return nil, errors.New("no property found")
-	}
+	return c.GetSession().Get(key)
 }
 
 // 移除链接属性
 func (c *Connection) RemoveProperty(key string) {
-	c.propertyLock.Lock()
-	defer c.propertyLock.Unlock()
-
-	//删除属性
-	delete(c.property, key)
+	c.GetSession().Remove(key)
 }
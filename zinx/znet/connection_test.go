@@ -0,0 +1,216 @@
+package znet
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"src/zinx/utils"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 不断重复输出同一段数据的Reader，模拟客户端源源不断发来的消息流
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.data)
+	}
+	return n, nil
+}
+
+// 构造一个只用于读路径测试的链接，消息流来自repeatReader
+func newBenchReadConn(data []byte) *Connection {
+	c := &Connection{dp: NewDataPack()}
+	c.reader = bufio.NewReaderSize(&repeatReader{data: data}, 4096)
+	c.frameReader = c.reader
+	c.headBuf = make([]byte, c.dp.GetHeadLen())
+	return c
+}
+
+// 池化读路径：每条消息的内存分配次数应为0
+func BenchmarkConnection_readRequest(b *testing.B) {
+	frame, err := NewDataPack().Pack(NewMsgPackage(1, make([]byte, 256)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := newBenchReadConn(frame)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, err := c.readRequest()
+		if err != nil {
+			b.Fatal(err)
+		}
+		req.Release()
+	}
+}
+
+// 原有读路径：每条消息分配DataPack、head、Message、data及Request，作为对比
+func BenchmarkConnection_readUnpooled(b *testing.B) {
+	frame, err := NewDataPack().Pack(NewMsgPackage(1, make([]byte, 256)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := &repeatReader{data: frame}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dp := NewDataPack()
+		headData := make([]byte, dp.GetHeadLen())
+		if _, err := io.ReadFull(r, headData); err != nil {
+			b.Fatal(err)
+		}
+		msg, err := dp.UnPack(headData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		data := make([]byte, msg.GetMsgLen())
+		if _, err := io.ReadFull(r, data); err != nil {
+			b.Fatal(err)
+		}
+		msg.SetData(data)
+		_ = &Request{msg: msg}
+	}
+}
+
+// 读路径解析出的消息与封包前一致，且Release后缓冲可以被复用
+func TestConnection_readRequest(t *testing.T) {
+	dp := NewDataPack()
+	frame1, _ := dp.Pack(NewMsgPackage(1, []byte("zinx")))
+	frame2, _ := dp.Pack(NewMsgPackage(2, nil))
+	c := newBenchReadConn(append(frame1, frame2...))
+
+	req, err := c.readRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetMsgID() != 1 || string(req.GetData()) != "zinx" {
+		t.Fatalf("got msgID=%d data=%q", req.GetMsgID(), req.GetData())
+	}
+	req.Release()
+
+	req, err = c.readRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetMsgID() != 2 || len(req.GetData()) != 0 {
+		t.Fatalf("got msgID=%d data=%q", req.GetMsgID(), req.GetData())
+	}
+	req.Release()
+}
+
+// Writer按优先级取出消息，高优先级连续发送达到上限后让低优先级发送一条
+func TestConnection_tryNextFrame(t *testing.T) {
+	c := &Connection{
+		highChan:    make(chan []byte, 16),
+		msgBuffChan: make(chan []byte, 16),
+		lowChan:     make(chan []byte, 16),
+	}
+	for i := 0; i < 10; i++ {
+		c.highChan <- []byte{'h'}
+	}
+	c.msgBuffChan <- []byte{'n'}
+	c.lowChan <- []byte{'l'}
+
+	var order []byte
+	for {
+		data, ok := c.tryNextFrame()
+		if !ok {
+			break
+		}
+		order = append(order, data[0])
+	}
+	//PriorityStarvationRatio默认为8
+	if got, want := string(order), "hhhhhhhhnhhl"; got != want {
+		t.Fatalf("order = %s, want %s", got, want)
+	}
+}
+
+// 只有传输层断开才保存会话，服务器自己设置的截止时间及主动关闭不算
+func TestConnection_markTransportLost(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int32
+	}{
+		{"eof", io.EOF, 1},
+		{"unexpected eof", io.ErrUnexpectedEOF, 1},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, 1},
+		{"deadline", &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}, 0},
+		{"closed", &net.OpError{Op: "read", Err: net.ErrClosed}, 0},
+		{"protocol", ErrMsgReplayed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Connection{}
+			c.markTransportLost(tt.err)
+			if c.transportLost != tt.want {
+				t.Fatalf("got %d, want %d", c.transportLost, tt.want)
+			}
+		})
+	}
+}
+
+// drop_oldest策略下队列容量为0时按drop_newest处理，不会一直空转
+func TestConnection_sendQueuedDropOldest(t *testing.T) {
+	policy := utils.GlobalObject.SendBuffFullPolicy
+	utils.GlobalObject.SendBuffFullPolicy = utils.SendBuffFullDropOldest
+	defer func() { utils.GlobalObject.SendBuffFullPolicy = policy }()
+
+	c := &Connection{msgBuffChan: make(chan []byte)}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+	frame := []byte{0, 0, 0, 0, 1, 0, 0, 0}
+	if err := c.sendQueued(c.msgBuffChan, frame); err != ErrSendBuffDropped {
+		t.Fatalf("unbuffered queue err = %v", err)
+	}
+
+	c.msgBuffChan = make(chan []byte, 1)
+	for i := 0; i < 3; i++ {
+		if err := c.sendQueued(c.msgBuffChan, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.GetBuffDropCount() != 3 {
+		t.Fatalf("drop count = %d", c.GetBuffDropCount())
+	}
+}
+
+// 超限次数在安静超过统计窗口之后重新计数
+func TestConnection_recordViolation(t *testing.T) {
+	window := utils.GlobalObject.RateLimitViolationWindow
+	utils.GlobalObject.RateLimitViolationWindow = 1000
+	defer func() { utils.GlobalObject.RateLimitViolationWindow = window }()
+
+	c := &Connection{}
+	start := time.Now()
+	steps := []struct {
+		after time.Duration
+		want  uint32
+	}{
+		{0, 1},
+		{500 * time.Millisecond, 2},
+		{1400 * time.Millisecond, 3},
+		{2400 * time.Millisecond, 1},
+		{2500 * time.Millisecond, 2},
+	}
+	for _, step := range steps {
+		if got := c.recordViolation(start.Add(step.after)); got != step.want {
+			t.Fatalf("after %v violations = %d, want %d", step.after, got, step.want)
+		}
+	}
+}
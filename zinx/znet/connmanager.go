@@ -3,42 +3,90 @@ package znet
 import (
 	"errors"
 	"fmt"
+	"reflect"
+	"src/zinx/utils"
 	"src/zinx/ziface"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
+// 重复登录被踢下线时发给旧链接的系统保留MsgID，数据为DuplicateLoginNotice
+const MsgIDDuplicateLogin uint32 = 0x00FFFF05
+
+var (
+	//用户没有绑定链接
+	ErrUserNotFound = errors.New("user not found")
+	//用户已经绑定了其他链接，DuplicateLoginPolicy为reject时返回
+	ErrUserAlreadyBound = errors.New("user already bound to another connection")
+)
+
+// 链接集合的一个分片
+type connShard struct {
+	connections map[uint32]ziface.IConnection //分片管理的链接集合
+	lock        sync.RWMutex                  //保护分片链接集合的读写锁
+}
+
 // 链接管理模块
+// 链接按connID分散到多个分片中，每个分片有自己的锁，避免大量链接同时建立/断开时争抢同一把锁
 type ConnManager struct {
-	connections map[uint32]ziface.IConnection //管理的链接集合
-	connLock    sync.RWMutex                  //保护链接集合的读写锁
+	shards      []*connShard                    //链接集合的分片
+	count       int64                           //当前链接总数
+	users       map[string][]ziface.IConnection //用户 -> 绑定的链接
+	connUsers   map[uint32]string               //connID -> 绑定的用户
+	userLock    sync.RWMutex                    //保护用户绑定的读写锁，需要同时持有分片锁时先加分片锁
+	closedStats ziface.ServerStats              //已经断开的链接的累计统计
+	statsLock   sync.Mutex                      //保护closedStats的锁
 }
 
 // 创建当前链接的方法
 func NewConnManager() *ConnManager {
-	return &ConnManager{
-		connections: make(map[uint32]ziface.IConnection),
+	shardCount := utils.GlobalObject.ConnMgrShardCount
+	if shardCount == 0 {
+		shardCount = 1
 	}
+	connMgr := &ConnManager{
+		shards:    make([]*connShard, shardCount),
+		users:     make(map[string][]ziface.IConnection),
+		connUsers: make(map[uint32]string),
+	}
+	for i := range connMgr.shards {
+		connMgr.shards[i] = &connShard{
+			connections: make(map[uint32]ziface.IConnection),
+		}
+	}
+	return connMgr
+}
+
+// 根据connID得到链接所在的分片
+func (connMgr *ConnManager) shard(connID uint32) *connShard {
+	//connID是递增分配的，直接取模即可均匀地分散到各分片
+	return connMgr.shards[connID%uint32(len(connMgr.shards))]
 }
 
 // 添加链接
 func (connMgr *ConnManager) Add(conn ziface.IConnection) {
-	//保护共享资源map，加 写锁
-	connMgr.connLock.Lock()
-	defer connMgr.connLock.Unlock()
-
+	//保护共享资源map，加 分片写锁
+	shard := connMgr.shard(conn.GetConnID())
+	shard.lock.Lock()
 	//将conn加入到ConnManager中
-	connMgr.connections[conn.GetConnID()] = conn
+	if _, ok := shard.connections[conn.GetConnID()]; !ok {
+		atomic.AddInt64(&connMgr.count, 1)
+	}
+	shard.connections[conn.GetConnID()] = conn
+	shard.lock.Unlock()
+
 	fmt.Println("connection", conn.GetConnID(), " add to ConnManager successfully:conn num=", connMgr.Len())
 }
 
 // 删除链接
 func (connMgr *ConnManager) Remove(conn ziface.IConnection) {
-	//保护共享资源map，加 写锁
-	connMgr.connLock.Lock()
-	defer connMgr.connLock.Unlock()
-
+	//保护共享资源map，加 分片写锁
+	shard := connMgr.shard(conn.GetConnID())
+	shard.lock.Lock()
 	//删除 链接信息
-	delete(connMgr.connections, conn.GetConnID())
+	connMgr.retire(shard, conn)
+	shard.lock.Unlock()
 
 	fmt.Println("connection", conn.GetConnID(), " remove from to ConnManager successfully:conn num=", connMgr.Len())
 
@@ -46,12 +94,12 @@ func (connMgr *ConnManager) Remove(conn ziface.IConnection) {
 
 // 根据connID获取链接
 func (connMgr *ConnManager) Get(connID uint32) (ziface.IConnection, error) {
-	//保护共享资源map，加 读锁
-
-	connMgr.connLock.RLock()
-	defer connMgr.connLock.RUnlock()
+	//保护共享资源map，加 分片读锁
+	shard := connMgr.shard(connID)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
 
-	if conn, ok := connMgr.connections[connID]; ok {
+	if conn, ok := shard.connections[connID]; ok {
 		//找到了
 		return conn, nil
 	} else {
@@ -61,21 +109,232 @@ func (connMgr *ConnManager) Get(connID uint32) (ziface.IConnection, error) {
 
 // 得到当前链接总数
 func (connMgr *ConnManager) Len() int {
-	return len(connMgr.connections)
+	return int(atomic.LoadInt64(&connMgr.count))
 }
 
 // 清除并终止所有的链接
 func (connMgr *ConnManager) ClearConn() {
-	//保护共享资源map，加 写锁
-	connMgr.connLock.Lock()
-	defer connMgr.connLock.Unlock()
+	//逐个分片加写锁，只在锁内摘除链接
+	var conns []ziface.IConnection
+	for _, shard := range connMgr.shards {
+		shard.lock.Lock()
+		for _, conn := range shard.connections {
+			conns = append(conns, conn)
+			//删除
+			connMgr.retire(shard, conn)
+		}
+		shard.lock.Unlock()
+	}
 
-	//删除conn并停止conn的工作
-	for connID, conn := range connMgr.connections {
-		//停止
+	//在锁外停止conn的工作，Stop内部会再调用Remove，持锁调用会死锁
+	for _, conn := range conns {
 		conn.Stop()
-		//删除
-		delete(connMgr.connections, connID)
 	}
 	fmt.Println("Clear All connection succ! conn num=", connMgr.Len())
 }
+
+// 遍历所有链接，f返回false时停止遍历
+// 逐个分片在锁内取出快照，在锁外调用f，遍历期间新加入或断开的链接不一定能遍历到
+func (connMgr *ConnManager) Range(f func(conn ziface.IConnection) bool) {
+	var conns []ziface.IConnection
+	for _, shard := range connMgr.shards {
+		conns = shard.snapshot(conns[:0])
+		for _, conn := range conns {
+			if !f(conn) {
+				return
+			}
+		}
+	}
+}
+
+// 得到当前所有链接的快照
+func (connMgr *ConnManager) Snapshot() []ziface.IConnection {
+	conns := make([]ziface.IConnection, 0, connMgr.Len())
+	for _, shard := range connMgr.shards {
+		conns = shard.snapshot(conns)
+	}
+	return conns
+}
+
+// 将分片中的链接追加到conns中
+func (shard *connShard) snapshot(conns []ziface.IConnection) []ziface.IConnection {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	for _, conn := range shard.connections {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// 查找属性key的值等于value的链接
+func (connMgr *ConnManager) FindByProperty(key string, value interface{}) []ziface.IConnection {
+	var found []ziface.IConnection
+	connMgr.Range(func(conn ziface.IConnection) bool {
+		//属性值可能是切片等不可比较的类型，不能直接用==比较
+		if v, err := conn.GetProperty(key); err == nil && reflect.DeepEqual(v, value) {
+			found = append(found, conn)
+		}
+		return true
+	})
+	return found
+}
+
+// 查找远程地址以prefix开头的链接
+func (connMgr *ConnManager) FindByRemoteAddrPrefix(prefix string) []ziface.IConnection {
+	var found []ziface.IConnection
+	connMgr.Range(func(conn ziface.IConnection) bool {
+		if addr := conn.RemoteAddr(); addr != nil && strings.HasPrefix(addr.String(), prefix) {
+			found = append(found, conn)
+		}
+		return true
+	})
+	return found
+}
+
+// 将链接从分片中删除、解除用户绑定，并把它的统计累加到已断开链接的统计中，调用者需持有分片写锁
+func (connMgr *ConnManager) retire(shard *connShard, conn ziface.IConnection) {
+	if c, ok := shard.connections[conn.GetConnID()]; !ok || c != conn {
+		return
+	}
+	delete(shard.connections, conn.GetConnID())
+	atomic.AddInt64(&connMgr.count, -1)
+
+	connMgr.userLock.Lock()
+	connMgr.unbind(conn)
+	connMgr.userLock.Unlock()
+
+	connMgr.statsLock.Lock()
+	connMgr.closedStats.Add(conn.Stats())
+	connMgr.statsLock.Unlock()
+}
+
+// 得到全服的流量统计，包含已经断开的链接
+func (connMgr *ConnManager) Stats() ziface.ServerStats {
+	connMgr.statsLock.Lock()
+	stats := ziface.ServerStats{
+		MsgIDCounts:    make(map[uint32]uint64),
+		BytesIn:        connMgr.closedStats.BytesIn,
+		BytesOut:       connMgr.closedStats.BytesOut,
+		MsgsIn:         connMgr.closedStats.MsgsIn,
+		MsgsOut:        connMgr.closedStats.MsgsOut,
+		BuffDropCount:  connMgr.closedStats.BuffDropCount,
+		ReadPauses:     connMgr.closedStats.ReadPauses,
+		ReadPausedTime: connMgr.closedStats.ReadPausedTime,
+		SlowDropCount:  connMgr.closedStats.SlowDropCount,
+	}
+	for msgID, n := range connMgr.closedStats.MsgIDCounts {
+		stats.MsgIDCounts[msgID] = n
+	}
+	connMgr.statsLock.Unlock()
+
+	for _, shard := range connMgr.shards {
+		shard.lock.RLock()
+		stats.Connections += len(shard.connections)
+		for _, conn := range shard.connections {
+			stats.Add(conn.Stats())
+		}
+		shard.lock.RUnlock()
+	}
+	return stats
+}
+
+// 将链接绑定到用户
+// 用户已绑定其他链接时：kick 在锁外给旧链接发送提示后关闭；reject 返回ErrUserAlreadyBound；multi 同时保留
+func (connMgr *ConnManager) Bind(userID string, conn ziface.IConnection) error {
+	kicked, err := connMgr.bind(userID, conn)
+	if err != nil {
+		return err
+	}
+
+	//关闭旧链接会调用Remove，需要在锁外进行；CloseWithMessage会等待消息发送完毕，不阻塞调用者
+	for _, old := range kicked {
+		fmt.Println("user", userID, "duplicate login, kick ConnID =", old.GetConnID())
+		go old.CloseWithMessage(MsgIDDuplicateLogin, []byte(utils.GlobalObject.DuplicateLoginNotice))
+	}
+	return nil
+}
+
+// 在锁内完成绑定，返回需要踢下线的旧链接
+func (connMgr *ConnManager) bind(userID string, conn ziface.IConnection) ([]ziface.IConnection, error) {
+	//持有分片读锁，保证绑定期间链接不会被Remove
+	shard := connMgr.shard(conn.GetConnID())
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	//已经断开(不在集合中)的链接不能绑定，否则断开时不会再解绑
+	if c, ok := shard.connections[conn.GetConnID()]; !ok || c != conn {
+		return nil, errors.New("connection not found")
+	}
+
+	connMgr.userLock.Lock()
+	defer connMgr.userLock.Unlock()
+
+	if old, ok := connMgr.connUsers[conn.GetConnID()]; ok && old == userID {
+		return nil, nil
+	}
+
+	var kicked []ziface.IConnection
+	if bound := connMgr.users[userID]; len(bound) > 0 {
+		switch utils.GlobalObject.DuplicateLoginPolicy {
+		case utils.DuplicateLoginReject:
+			return nil, ErrUserAlreadyBound
+		case utils.DuplicateLoginMulti:
+		default:
+			//kick 旧链接立即解绑，之后GetByUser只会得到新链接
+			kicked = append(kicked, bound...)
+			for _, old := range kicked {
+				connMgr.unbind(old)
+			}
+		}
+	}
+	//链接之前绑定了其他用户，先解绑
+	connMgr.unbind(conn)
+	connMgr.users[userID] = append(connMgr.users[userID], conn)
+	connMgr.connUsers[conn.GetConnID()] = userID
+	return kicked, nil
+}
+
+// 根据用户获取绑定的链接
+func (connMgr *ConnManager) GetByUser(userID string) ([]ziface.IConnection, error) {
+	connMgr.userLock.RLock()
+	defer connMgr.userLock.RUnlock()
+
+	bound := connMgr.users[userID]
+	if len(bound) == 0 {
+		return nil, ErrUserNotFound
+	}
+	conns := make([]ziface.IConnection, len(bound))
+	copy(conns, bound)
+	return conns, nil
+}
+
+// 解除链接与用户的绑定
+func (connMgr *ConnManager) Unbind(conn ziface.IConnection) {
+	connMgr.userLock.Lock()
+	defer connMgr.userLock.Unlock()
+
+	connMgr.unbind(conn)
+}
+
+// 解除链接与用户的绑定，调用者需持有userLock写锁
+func (connMgr *ConnManager) unbind(conn ziface.IConnection) {
+	userID, ok := connMgr.connUsers[conn.GetConnID()]
+	if !ok {
+		return
+	}
+	delete(connMgr.connUsers, conn.GetConnID())
+
+	bound := connMgr.users[userID]
+	for i, c := range bound {
+		if c == conn {
+			bound = append(bound[:i], bound[i+1:]...)
+			break
+		}
+	}
+	if len(bound) == 0 {
+		delete(connMgr.users, userID)
+	} else {
+		connMgr.users[userID] = bound
+	}
+}
@@ -0,0 +1,160 @@
+package znet
+
+import (
+	"fmt"
+	"src/zinx/utils"
+	"src/zinx/ziface"
+	"sync"
+	"testing"
+)
+
+// 按重复登录策略切换配置，返回恢复原配置的函数
+func setDuplicateLoginPolicy(policy string) func() {
+	old := utils.GlobalObject.DuplicateLoginPolicy
+	utils.GlobalObject.DuplicateLoginPolicy = policy
+	return func() { utils.GlobalObject.DuplicateLoginPolicy = old }
+}
+
+func connIDs(conns []ziface.IConnection) []uint32 {
+	ids := make([]uint32, 0, len(conns))
+	for _, conn := range conns {
+		ids = append(ids, conn.GetConnID())
+	}
+	return ids
+}
+
+// 同一用户重复绑定时的kick、reject、multi策略
+func TestConnManager_bindPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		err     error
+		kicked  []uint32
+		byUser  []uint32
+		oldUser bool //旧链接是否仍然绑定该用户
+	}{
+		{utils.DuplicateLoginKick, nil, []uint32{1}, []uint32{2}, false},
+		{utils.DuplicateLoginReject, ErrUserAlreadyBound, nil, []uint32{1}, true},
+		{utils.DuplicateLoginMulti, nil, nil, []uint32{1, 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			defer setDuplicateLoginPolicy(tt.policy)()
+			connMgr := NewConnManager()
+			old, conn := &Connection{ConnID: 1}, &Connection{ConnID: 2}
+			connMgr.Add(old)
+			connMgr.Add(conn)
+
+			if _, err := connMgr.bind("user", old); err != nil {
+				t.Fatal(err)
+			}
+			kicked, err := connMgr.bind("user", conn)
+			if err != tt.err {
+				t.Fatalf("bind err = %v, want %v", err, tt.err)
+			}
+			if got := connIDs(kicked); !equalSeqs(got, tt.kicked) {
+				t.Fatalf("kicked = %v, want %v", got, tt.kicked)
+			}
+			bound, err := connMgr.GetByUser("user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := connIDs(bound); !equalSeqs(got, tt.byUser) {
+				t.Fatalf("GetByUser = %v, want %v", got, tt.byUser)
+			}
+			if _, ok := connMgr.connUsers[old.ConnID]; ok != tt.oldUser {
+				t.Fatalf("old conn bound = %v, want %v", ok, tt.oldUser)
+			}
+		})
+	}
+}
+
+// 已经移除的链接不能绑定，移除链接时自动解除绑定
+func TestConnManager_bindRemoved(t *testing.T) {
+	connMgr := NewConnManager()
+	conn := &Connection{ConnID: 1}
+	connMgr.Add(conn)
+	if err := connMgr.Bind("user", conn); err != nil {
+		t.Fatal(err)
+	}
+
+	connMgr.Remove(conn)
+	if _, err := connMgr.GetByUser("user"); err != ErrUserNotFound {
+		t.Fatalf("GetByUser after Remove err = %v", err)
+	}
+	if err := connMgr.Bind("user", conn); err == nil {
+		t.Fatal("removed conn bound")
+	}
+	if len(connMgr.users) != 0 || len(connMgr.connUsers) != 0 {
+		t.Fatalf("users = %v, connUsers = %v", connMgr.users, connMgr.connUsers)
+	}
+
+	//同一个connID的另一个链接对象不会被误删
+	connMgr.Add(conn)
+	connMgr.Remove(&Connection{ConnID: 1})
+	if connMgr.Len() != 1 {
+		t.Fatalf("Len = %d, want 1", connMgr.Len())
+	}
+}
+
+// 并发的Bind与Remove，结束后不能留下已经移除的链接的绑定，需要配合-race运行
+func TestConnManager_concurrentBindRemove(t *testing.T) {
+	defer setDuplicateLoginPolicy(utils.DuplicateLoginMulti)()
+	connMgr := NewConnManager()
+
+	var wg sync.WaitGroup
+	for i := uint32(0); i < 200; i++ {
+		conn := &Connection{ConnID: i}
+		connMgr.Add(conn)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			connMgr.Bind(fmt.Sprint("user", conn.ConnID%8), conn)
+		}()
+		go func() {
+			defer wg.Done()
+			connMgr.Remove(conn)
+		}()
+	}
+	wg.Wait()
+
+	if connMgr.Len() != 0 || len(connMgr.users) != 0 || len(connMgr.connUsers) != 0 {
+		t.Fatalf("Len = %d, users = %d, connUsers = %d", connMgr.Len(), len(connMgr.users), len(connMgr.connUsers))
+	}
+}
+
+// 分片之后的链接总数：并发添加、重复添加及删除
+func TestConnManager_shardedLen(t *testing.T) {
+	shardCount := utils.GlobalObject.ConnMgrShardCount
+	utils.GlobalObject.ConnMgrShardCount = 8
+	defer func() { utils.GlobalObject.ConnMgrShardCount = shardCount }()
+	connMgr := NewConnManager()
+
+	conns := make([]*Connection, 1000)
+	var wg sync.WaitGroup
+	for i := range conns {
+		conns[i] = &Connection{ConnID: uint32(i)}
+		wg.Add(1)
+		go func(conn *Connection) {
+			defer wg.Done()
+			connMgr.Add(conn)
+			connMgr.Add(conn)
+		}(conns[i])
+	}
+	wg.Wait()
+	if connMgr.Len() != len(conns) || len(connMgr.Snapshot()) != len(conns) {
+		t.Fatalf("Len = %d, Snapshot = %d, want %d", connMgr.Len(), len(connMgr.Snapshot()), len(conns))
+	}
+
+	for i := range conns[:500] {
+		wg.Add(1)
+		go func(conn *Connection) {
+			defer wg.Done()
+			connMgr.Remove(conn)
+			connMgr.Remove(conn)
+		}(conns[i])
+	}
+	wg.Wait()
+	if connMgr.Len() != 500 {
+		t.Fatalf("Len = %d, want 500", connMgr.Len())
+	}
+}
@@ -0,0 +1,80 @@
+package znet
+
+import (
+	"src/zinx/ziface"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 链接的流量统计，计数器都通过原子操作读写
+type connStats struct {
+	bytesIn       uint64
+	bytesOut      uint64
+	msgsIn        uint64
+	connectTime   time.Time
+	lastReadTime  int64 //UnixNano
+	lastWriteTime int64 //UnixNano
+
+	//按MsgID统计的收到的消息数
+	msgIDCounts map[uint32]uint64
+	msgIDLock   sync.Mutex
+}
+
+// 记录收到了一条消息
+func (s *connStats) recordRead(msgID uint32, n int) {
+	atomic.AddUint64(&s.bytesIn, uint64(n))
+	atomic.AddUint64(&s.msgsIn, 1)
+	atomic.StoreInt64(&s.lastReadTime, time.Now().UnixNano())
+
+	s.msgIDLock.Lock()
+	if s.msgIDCounts == nil {
+		s.msgIDCounts = make(map[uint32]uint64)
+	}
+	s.msgIDCounts[msgID]++
+	s.msgIDLock.Unlock()
+}
+
+// 记录发送了一批数据
+func (s *connStats) recordWrite(n int64) {
+	atomic.AddUint64(&s.bytesOut, uint64(n))
+	atomic.StoreInt64(&s.lastWriteTime, time.Now().UnixNano())
+}
+
+// 将UnixNano时间戳转换为time.Time，0表示从未发生
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// 获取当前链接的流量统计快照
+func (c *Connection) Stats() ziface.ConnStats {
+	c.stats.msgIDLock.Lock()
+	msgIDCounts := make(map[uint32]uint64, len(c.stats.msgIDCounts))
+	for msgID, n := range c.stats.msgIDCounts {
+		msgIDCounts[msgID] = n
+	}
+	c.stats.msgIDLock.Unlock()
+
+	return ziface.ConnStats{
+		ConnID:         c.ConnID,
+		BytesIn:        atomic.LoadUint64(&c.stats.bytesIn),
+		BytesOut:       atomic.LoadUint64(&c.stats.bytesOut),
+		MsgsIn:         atomic.LoadUint64(&c.stats.msgsIn),
+		MsgsOut:        atomic.LoadUint64(&c.writeFrames),
+		MsgIDCounts:    msgIDCounts,
+		ConnectTime:    c.stats.connectTime,
+		LastReadTime:   unixNanoTime(atomic.LoadInt64(&c.stats.lastReadTime)),
+		LastWriteTime:  unixNanoTime(atomic.LoadInt64(&c.stats.lastWriteTime)),
+		SendQueueLen:   c.sendQueueLen(),
+		BuffDropCount:  c.GetBuffDropCount(),
+		WriteBatches:   atomic.LoadUint64(&c.writeBatches),
+		InFlight:       c.GetInFlight(),
+		ReadPauses:     atomic.LoadUint64(&c.readPauses),
+		ReadPausedTime: time.Duration(atomic.LoadInt64(&c.readPausedTime)),
+		SlowCount:      atomic.LoadUint64(&c.slowCount),
+		SlowDropCount:  atomic.LoadUint64(&c.slowDropCount),
+	}
+}
@@ -0,0 +1,280 @@
+package znet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"src/zinx/utils"
+	"src/zinx/ziface"
+	"sync"
+)
+
+// 加密通道使用的系统保留MsgID
+// 服务器 -> 客户端：X25519临时公钥(32字节) + 服务器长期Ed25519密钥对临时公钥的签名(64字节)
+// 客户端 -> 服务器：X25519临时公钥(32字节)
+// 两个方向的密钥交换消息都不加密
+const (
+	MsgIDHandshake uint32 = 0x00FFFF04
+)
+
+// 签名临时公钥时附加的上下文，避免签名被挪作他用
+const handshakeSignContext = "zinx handshake v1"
+
+var (
+	//开启加密之后收到了未加密的消息
+	ErrMsgNotEncrypted = errors.New("msg not encrypted")
+	//收到的加密消息计数器没有递增，可能是重放或nonce重用
+	ErrMsgReplayed = errors.New("encrypted msg replayed")
+	//消息解密或认证失败
+	ErrMsgDecrypt = errors.New("encrypted msg authentication failed")
+	//服务器临时公钥的签名校验失败，可能遭到中间人攻击
+	ErrHandshakeSignature = errors.New("handshake signature verify failed")
+	//密钥交换完成之前不能发送消息，否则消息会以明文发出
+	ErrHandshakePending = errors.New("handshake not finished, msg not sent")
+)
+
+// 密钥派生时两个方向使用的info
+const (
+	cipherInfoC2S = "zinx c2s"
+	cipherInfoS2C = "zinx s2c"
+)
+
+// 一个方向的消息加解密对象，使用AES-256-GCM
+// nonce由4字节0和8字节递增计数器组成，计数器写在消息扩展头中
+// 发送和接收都要求在同一个Goroutine中按顺序调用
+type FrameCipher struct {
+	aead    cipher.AEAD
+	counter uint64 //发送：最后使用的计数器；接收：最后收到的计数器
+}
+
+// 根据密钥创建加解密对象
+func newFrameCipher(key []byte) (*FrameCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FrameCipher{aead: aead}, nil
+}
+
+// 生成一个X25519临时密钥
+func newHandshakeKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+var (
+	signKeyOnce sync.Once
+	signKey     ed25519.PrivateKey
+	signKeyErr  error
+)
+
+// 得到配置的服务器长期签名私钥
+func serverSignKey() (ed25519.PrivateKey, error) {
+	signKeyOnce.Do(func() {
+		seed, err := hex.DecodeString(utils.GlobalObject.EncryptionSignKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			signKeyErr = errors.New("EncryptionSignKey must be a hex encoded 32 bytes ed25519 seed")
+			return
+		}
+		signKey = ed25519.NewKeyFromSeed(seed)
+	})
+	return signKey, signKeyErr
+}
+
+// 生成一个服务器长期签名密钥，seed填入EncryptionSignKey，公钥交给客户端用来校验密钥交换
+func GenerateHandshakeSignKey() (seed string, pub ed25519.PublicKey, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(priv.Seed()), pub, nil
+}
+
+// 得到配置的服务器长期签名公钥
+func HandshakeSignPublicKey() (ed25519.PublicKey, error) {
+	key, err := serverSignKey()
+	if err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// 由X25519共享密钥派生两个方向的加解密对象
+func deriveCiphers(priv *ecdh.PrivateKey, peerPub, serverPub, clientPub []byte) (c2s, s2c *FrameCipher, err error) {
+	pub, err := ecdh.X25519().NewPublicKey(peerPub)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	salt := append(append([]byte{}, serverPub...), clientPub...)
+
+	c2sKey, err := hkdf.Key(sha256.New, secret, salt, cipherInfoC2S, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	s2cKey, err := hkdf.Key(sha256.New, secret, salt, cipherInfoS2C, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c2s, err = newFrameCipher(c2sKey); err != nil {
+		return nil, nil, err
+	}
+	if s2c, err = newFrameCipher(s2cKey); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
+
+// 客户端完成密钥交换：用预先配置(固定)的服务器签名公钥校验服务器下发的临时公钥，
+// 返回要发给服务器的客户端公钥，以及客户端发送、接收使用的加解密对象
+func ClientHandshake(serverMsg []byte, serverSignPub ed25519.PublicKey) (clientPub []byte, send *FrameCipher, recv *FrameCipher, err error) {
+	if len(serverMsg) != 32+ed25519.SignatureSize || len(serverSignPub) != ed25519.PublicKeySize {
+		return nil, nil, nil, ErrHandshakeSignature
+	}
+	serverPub := serverMsg[:32]
+	if !ed25519.Verify(serverSignPub, append([]byte(handshakeSignContext), serverPub...), serverMsg[32:]) {
+		return nil, nil, nil, ErrHandshakeSignature
+	}
+
+	priv, err := newHandshakeKey()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientPub = priv.PublicKey().Bytes()
+	c2s, s2c, err := deriveCiphers(priv, serverPub, serverPub, clientPub)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return clientPub, c2s, s2c, nil
+}
+
+// 加密一个已经封包的消息，返回新的消息，不修改frame
+// 扩展头中原有的字段保持明文并参与认证，消息的内容被加密
+func (fc *FrameCipher) SealFrame(frame []byte) ([]byte, error) {
+	if len(frame) < 8 {
+		return nil, errors.New("msg head too short")
+	}
+	id := binary.LittleEndian.Uint32(frame[4:8])
+	flags := uint8(id >> msgFlagShift)
+	if flags&MsgFlagEncrypted != 0 {
+		return frame, nil
+	}
+	pre := 8 + int(extLen(flags))
+	if len(frame) < pre {
+		return nil, errors.New("msg ext head too short")
+	}
+
+	fc.counter++
+	out := make([]byte, pre+8, pre+8+len(frame)-pre+fc.aead.Overhead())
+	copy(out, frame[:pre])
+	binary.LittleEndian.PutUint32(out[4:8], id|uint32(MsgFlagEncrypted)<<msgFlagShift)
+	binary.LittleEndian.PutUint64(out[pre:], fc.counter)
+
+	var nonce [12]byte
+	binary.LittleEndian.PutUint64(nonce[4:], fc.counter)
+	//MsgID、标志位及明文的扩展头作为附加认证数据
+	out = fc.aead.Seal(out, nonce[:], frame[pre:], out[4:pre])
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(out)-8))
+	return out, nil
+}
+
+// 解密一条读完数据、尚未拆包扩展头的消息，解密之后去掉加密标志及计数器，原地修改消息的数据
+func (fc *FrameCipher) OpenMsg(msg ziface.IMessage) error {
+	flags := msg.GetFlags()
+	if flags&MsgFlagEncrypted == 0 {
+		return ErrMsgNotEncrypted
+	}
+	data := msg.GetData()
+	pre := int(extLen(flags &^ MsgFlagEncrypted))
+	if len(data) < pre+8+fc.aead.Overhead() {
+		return errors.New("msg ext head too short")
+	}
+	counter := binary.LittleEndian.Uint64(data[pre:])
+	if counter <= fc.counter {
+		return ErrMsgReplayed
+	}
+
+	var nonce [12]byte
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	var aadBuf [32]byte
+	aad := binary.LittleEndian.AppendUint32(aadBuf[:0], msg.GetMsgId()&MsgIdMask|uint32(flags)<<msgFlagShift)
+	aad = append(aad, data[:pre]...)
+
+	ciphertext := data[pre+8:]
+	plain, err := fc.aead.Open(ciphertext[:0], nonce[:], ciphertext, aad)
+	if err != nil {
+		return ErrMsgDecrypt
+	}
+	fc.counter = counter
+
+	//把明文的扩展头挪到紧挨着明文的位置，之后按未加密的消息拆包扩展头
+	copy(data[8:8+pre], data[:pre])
+	data = data[8 : pre+8+len(plain)]
+	msg.SetFlags(flags &^ MsgFlagEncrypted)
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	return nil
+}
+
+// 服务端开始密钥交换：把临时公钥及长期密钥对它的签名发给客户端
+func (c *Connection) startHandshake() error {
+	key, err := serverSignKey()
+	if err != nil {
+		return err
+	}
+	pub := c.handshakeKey.PublicKey().Bytes()
+	sig := ed25519.Sign(key, append([]byte(handshakeSignContext), pub...))
+	return c.SendBuffMsg(MsgIDHandshake, append(pub, sig...))
+}
+
+// 处理客户端发来的公钥，派生两个方向的密钥，之后的消息都必须加密
+func (c *Connection) handleHandshake(req *Request) error {
+	defer req.Release()
+	if req.GetMsgID() != MsgIDHandshake {
+		return errors.New("first msg is not handshake")
+	}
+
+	serverPub := c.handshakeKey.PublicKey().Bytes()
+	c2s, s2c, err := deriveCiphers(c.handshakeKey, req.GetData(), serverPub, req.GetData())
+	if err != nil {
+		return err
+	}
+	c.recvCipher = c2s
+	c.sendCipher.Store(s2c)
+	return nil
+}
+
+// 开启加密的链接在密钥交换完成之前，只允许发送密钥交换消息
+// 其它消息不会等待密钥交换完成，直接返回ErrHandshakePending由调用者决定是否重发
+func (c *Connection) checkHandshakeDone(binaryMsg []byte) error {
+	if !c.encrypted || c.sendCipher.Load() != nil || frameMsgID(binaryMsg) == MsgIDHandshake {
+		return nil
+	}
+	return ErrHandshakePending
+}
+
+// Writer发送之前加密消息，密钥交换本身的消息不加密
+// 发送前已经检查过密钥交换，这里再次拒绝未加密的消息，保证不会以明文发出
+func (c *Connection) sealFrame(frame []byte) ([]byte, error) {
+	if !c.encrypted || frameMsgID(frame) == MsgIDHandshake {
+		return frame, nil
+	}
+	fc := c.sendCipher.Load()
+	if fc == nil {
+		return nil, ErrHandshakePending
+	}
+	return fc.SealFrame(frame)
+}
@@ -0,0 +1,228 @@
+package znet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"src/zinx/utils"
+	"testing"
+)
+
+// 配置测试用的服务器签名私钥，返回对应的公钥，测试结束后恢复原配置
+// 签名私钥第一次使用之后就被缓存，之后的测试得到的都是同一个公钥
+func testSignPublicKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	if utils.GlobalObject.EncryptionSignKey == "" {
+		seed, _, err := GenerateHandshakeSignKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.GlobalObject.EncryptionSignKey = seed
+		t.Cleanup(func() { utils.GlobalObject.EncryptionSignKey = "" })
+	}
+	pub, err := HandshakeSignPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+// 构造一个只用于加密测试的服务端链接，并取出它下发的密钥交换消息
+func newCryptoConn(t *testing.T) (*Connection, []byte) {
+	t.Helper()
+	testSignPublicKey(t)
+	key, err := newHandshakeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Connection{dp: NewDataPack(), encrypted: true, handshakeKey: key, msgBuffChan: make(chan []byte, 4)}
+	if err := c.startHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	frame := <-c.msgBuffChan
+	return c, frame[8:]
+}
+
+// 服务端的读路径从frames中读取消息
+func setCryptoReader(c *Connection, frames ...[]byte) {
+	c.reader = bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil)))
+	c.frameReader = c.reader
+	c.headBuf = make([]byte, c.dp.GetHeadLen())
+}
+
+// 完成一次密钥交换，返回服务端链接及客户端的加解密对象
+func handshakeCryptoConn(t *testing.T) (c *Connection, send, recv *FrameCipher) {
+	t.Helper()
+	c, serverMsg := newCryptoConn(t)
+	clientPub, send, recv, err := ClientHandshake(serverMsg, testSignPublicKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := c.dp.Pack(NewMsgPackage(MsgIDHandshake, clientPub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCryptoReader(c, hs)
+	req, err := c.readRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.handleHandshake(req); err != nil {
+		t.Fatal(err)
+	}
+	return c, send, recv
+}
+
+// 用客户端的加密对象封包并加密一条消息
+func sealClientMsg(t *testing.T, fc *FrameCipher, msg *Message) []byte {
+	t.Helper()
+	frame, err := NewDataPack().Pack(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := fc.SealFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestCrypto_RoundTrip(t *testing.T) {
+	c, send, recv := handshakeCryptoConn(t)
+
+	//客户端 -> 服务端：带请求ID的消息，扩展头明文保留并参与认证
+	msg := NewMsgPackage(7, []byte("hello"))
+	msg.Flags |= MsgFlagRequest
+	msg.ReqID = 42
+	setCryptoReader(c, sealClientMsg(t, send, msg))
+	req, err := c.readRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetMsgID() != 7 || req.message.ReqID != 42 || string(req.GetData()) != "hello" {
+		t.Fatalf("got id=%d reqID=%d data=%q", req.GetMsgID(), req.message.ReqID, req.GetData())
+	}
+	req.Release()
+
+	//服务端 -> 客户端
+	frame, err := c.dp.Pack(NewMsgPackage(8, []byte("world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.sealFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := c.dp.UnPack(sealed[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.SetData(sealed[8:])
+	if err := recv.OpenMsg(head); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.dp.UnPackExt(head); err != nil {
+		t.Fatal(err)
+	}
+	if head.GetMsgId() != 8 || string(head.GetData()) != "world" {
+		t.Fatalf("got id=%d data=%q", head.GetMsgId(), head.GetData())
+	}
+}
+
+func TestCrypto_OpenErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		//返回客户端依次发送的消息
+		frames func(send *FrameCipher) [][]byte
+		want   error
+	}{
+		{"replayed", func(send *FrameCipher) [][]byte {
+			f := sealClientMsg(t, send, NewMsgPackage(1, []byte("a")))
+			return [][]byte{f, f}
+		}, ErrMsgReplayed},
+		{"out of order", func(send *FrameCipher) [][]byte {
+			f1 := sealClientMsg(t, send, NewMsgPackage(1, []byte("a")))
+			f2 := sealClientMsg(t, send, NewMsgPackage(1, []byte("b")))
+			return [][]byte{f2, f1}
+		}, ErrMsgReplayed},
+		{"tampered aad", func(send *FrameCipher) [][]byte {
+			f := sealClientMsg(t, send, NewMsgPackage(1, []byte("a")))
+			id := binary.LittleEndian.Uint32(f[4:8])
+			binary.LittleEndian.PutUint32(f[4:8], id^2)
+			return [][]byte{f}
+		}, ErrMsgDecrypt},
+		{"not encrypted", func(send *FrameCipher) [][]byte {
+			f, _ := NewDataPack().Pack(NewMsgPackage(1, []byte("a")))
+			return [][]byte{f}
+		}, ErrMsgNotEncrypted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, send, _ := handshakeCryptoConn(t)
+			setCryptoReader(c, tt.frames(send)...)
+			var err error
+			for err == nil {
+				var req *Request
+				if req, err = c.readRequest(); err == nil {
+					req.Release()
+				}
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// 第一条消息不是密钥交换时拒绝，密钥交换完成之前只允许发出密钥交换消息
+func TestCrypto_HandshakeRequired(t *testing.T) {
+	c, _ := newCryptoConn(t)
+
+	frame, err := c.dp.Pack(NewMsgPackage(1, []byte("plain")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkHandshakeDone(frame); err != ErrHandshakePending {
+		t.Fatalf("checkHandshakeDone got %v", err)
+	}
+	if _, err := c.sealFrame(frame); err != ErrHandshakePending {
+		t.Fatalf("sealFrame got %v", err)
+	}
+
+	setCryptoReader(c, frame)
+	req, err := c.readRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.handleHandshake(req); err == nil {
+		t.Fatal("non-handshake first msg accepted")
+	}
+	if c.sendCipher.Load() != nil {
+		t.Fatal("send cipher set after failed handshake")
+	}
+}
+
+// 客户端用固定的服务器签名公钥校验密钥交换消息
+func TestCrypto_ClientVerifySignature(t *testing.T) {
+	pub := testSignPublicKey(t)
+	_, serverMsg := newCryptoConn(t)
+
+	_, otherPub, err := GenerateHandshakeSignKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ClientHandshake(serverMsg, otherPub); err != ErrHandshakeSignature {
+		t.Fatalf("wrong pinned key got %v", err)
+	}
+
+	tampered := append([]byte{}, serverMsg...)
+	tampered[0] ^= 1
+	if _, _, _, err := ClientHandshake(tampered, pub); err != ErrHandshakeSignature {
+		t.Fatalf("tampered server key got %v", err)
+	}
+	if _, _, _, err := ClientHandshake(serverMsg[:32], pub); err != ErrHandshakeSignature {
+		t.Fatalf("unsigned server key got %v", err)
+	}
+}
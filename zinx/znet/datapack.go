@@ -10,6 +10,13 @@ import (
 
 //封包、拆包 的具体模块
 
+var (
+	//MsgID超出了低24位，高8位是消息标志位
+	ErrMsgIdTooLarge = errors.New("msg id exceeds 24 bits")
+	//消息带有未定义的标志位，可能是使用32位MsgID的旧客户端
+	ErrUnknownMsgFlags = errors.New("unknown msg flags")
+)
+
 type DataPack struct {
 }
 
@@ -26,19 +33,57 @@ func (dp *DataPack) GetHeadLen() uint32 {
 
 // 封包方法
 func (dp *DataPack) Pack(msg ziface.IMessage) ([]byte, error) {
+	//高8位用作标志位，不能悄悄截断业务的MsgID
+	if msg.GetMsgId() > MsgIdMask {
+		return nil, ErrMsgIdTooLarge
+	}
 	//创建一个存放bytes字节的缓冲
 	dataBuff := bytes.NewBuffer([]byte{})
 
-	//将dataLen写进databuff中
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgLen()); err != nil {
+	//压缩消息先压缩数据，压缩之后没有变小则按未压缩发送
+	flags, data, dataLen := msg.GetFlags(), msg.GetData(), msg.GetMsgLen()
+	if flags&MsgFlagCompressed != 0 {
+		compressor, ok := GetCompressor(msg.GetCompressor())
+		if !ok {
+			return nil, ErrUnknownCompressor
+		}
+		compressed, err := compressor.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			data, dataLen = compressed, uint32(len(compressed))
+		} else {
+			flags &^= MsgFlagCompressed
+		}
+	}
+
+	//将dataLen写进databuff中，dataLen包含扩展头的长度
+	if err := binary.Write(dataBuff, binary.LittleEndian, dataLen+extLen(flags)); err != nil {
 		return nil, err
 	}
-	//将MsgId写进databuff中
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgId()); err != nil {
+	//将MsgId及标志位写进databuff中
+	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgId()&MsgIdMask|uint32(flags)<<msgFlagShift); err != nil {
 		return nil, err
 	}
+	//将扩展头写进databuff中
+	if flags&MsgFlagReliable != 0 {
+		if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetSeq()); err != nil {
+			return nil, err
+		}
+	}
+	if flags&(MsgFlagRequest|MsgFlagResponse) != 0 {
+		if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetReqID()); err != nil {
+			return nil, err
+		}
+	}
+	if flags&MsgFlagCompressed != 0 {
+		if err := dataBuff.WriteByte(msg.GetCompressor()); err != nil {
+			return nil, err
+		}
+	}
 	//将data数据 写进databuff中
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetData()); err != nil {
+	if err := binary.Write(dataBuff, binary.LittleEndian, data); err != nil {
 		return nil, err
 	}
 
@@ -61,9 +106,97 @@ func (dp *DataPack) UnPack(binaryData []byte) (ziface.IMessage, error) {
 	if err := binary.Read(dataBuff, binary.LittleEndian, &msg.Id); err != nil {
 		return nil, err
 	}
+	//拆分出标志位
+	msg.Flags = uint8(msg.Id >> msgFlagShift)
+	msg.Id &= MsgIdMask
+	if msg.Flags&^msgFlagsKnown != 0 {
+		return nil, ErrUnknownMsgFlags
+	}
 	//判断datalen是否已经超出了我们允许的最大包长度
 	if utils.GlobalObject.MaxPacketSize > 0 && msg.DataLen > utils.GlobalObject.MaxPacketSize {
 		return nil, errors.New("too large msg size")
 	}
 	return msg, nil
 }
+
+// 拆包扩展头方法 读完消息数据之后，按标志位从数据中解析出扩展头，剩下的才是消息的内容
+// 压缩消息会被自动解压
+func (dp *DataPack) UnPackExt(msg ziface.IMessage) error {
+	data := msg.GetData()
+	if uint32(len(data)) < extLen(msg.GetFlags()) {
+		return errors.New("msg ext head too short")
+	}
+	//加密消息需要先用FrameCipher解密
+	if msg.GetFlags()&MsgFlagEncrypted != 0 {
+		return errors.New("encrypted msg must be opened first")
+	}
+	//读可靠消息的序列号
+	if msg.GetFlags()&MsgFlagReliable != 0 {
+		msg.SetSeq(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+	}
+	//读请求ID
+	if msg.GetFlags()&(MsgFlagRequest|MsgFlagResponse) != 0 {
+		msg.SetReqID(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+	}
+	//读压缩算法ID，并解压数据
+	if msg.GetFlags()&MsgFlagCompressed != 0 {
+		msg.SetCompressor(data[0])
+		compressor, ok := GetCompressor(data[0])
+		if !ok {
+			return ErrUnknownCompressor
+		}
+		decompressed, err := compressor.Decompress(data[1:], int(utils.GlobalObject.MaxDecompressLen))
+		if err != nil {
+			return err
+		}
+		data = decompressed
+	}
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	return nil
+}
+
+// 按标志位计算扩展头的长度
+func extLen(flags uint8) uint32 {
+	var n uint32
+	if flags&MsgFlagReliable != 0 {
+		n += 4
+	}
+	if flags&(MsgFlagRequest|MsgFlagResponse) != 0 {
+		n += 4
+	}
+	if flags&MsgFlagCompressed != 0 {
+		n += 1
+	}
+	if flags&MsgFlagEncrypted != 0 {
+		n += 8
+	}
+	return n
+}
+
+// 拆包方法 将head信息直接解析到msg中，不产生额外的内存分配，供链接的读路径使用
+func (dp *DataPack) UnPackTo(binaryData []byte, msg *Message) error {
+	if uint32(len(binaryData)) < dp.GetHeadLen() {
+		return errors.New("msg head too short")
+	}
+	//读dataLen
+	msg.DataLen = binary.LittleEndian.Uint32(binaryData[0:4])
+	//读MsgId及标志位
+	id := binary.LittleEndian.Uint32(binaryData[4:8])
+	msg.Id = id & MsgIdMask
+	msg.Flags = uint8(id >> msgFlagShift)
+	if msg.Flags&^msgFlagsKnown != 0 {
+		return ErrUnknownMsgFlags
+	}
+	msg.Seq = 0
+	msg.Compressor = 0
+	msg.ReqID = 0
+	msg.Data = nil
+	//判断datalen是否已经超出了我们允许的最大包长度
+	if utils.GlobalObject.MaxPacketSize > 0 && msg.DataLen > utils.GlobalObject.MaxPacketSize {
+		return errors.New("too large msg size")
+	}
+	return nil
+}
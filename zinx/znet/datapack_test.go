@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -114,3 +115,62 @@ func TestDataPack_GetHeadLen(t *testing.T) {
 	select {}
 
 }
+
+// 压缩消息封包之后带有压缩标志，拆包时自动解压
+func TestDataPack_Compressed(t *testing.T) {
+	dp := NewDataPack()
+	data := []byte(strings.Repeat(`{"x":1,"y":2},`, 100))
+
+	for _, id := range []uint8{CompressorGzip, CompressorDeflate} {
+		msg := NewMsgPackage(3, data)
+		msg.Flags = MsgFlagReliable | MsgFlagCompressed
+		msg.Seq = 7
+		msg.Compressor = id
+		binaryMsg, err := dp.Pack(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(binaryMsg) >= len(data) {
+			t.Fatalf("compressor %d: packed len %d not smaller than %d", id, len(binaryMsg), len(data))
+		}
+
+		head, err := dp.UnPack(binaryMsg[:dp.GetHeadLen()])
+		if err != nil {
+			t.Fatal(err)
+		}
+		head.SetData(binaryMsg[dp.GetHeadLen():])
+		if err := dp.UnPackExt(head); err != nil {
+			t.Fatal(err)
+		}
+		if head.GetMsgId() != 3 || head.GetSeq() != 7 || head.GetCompressor() != id || string(head.GetData()) != string(data) {
+			t.Fatalf("compressor %d: got msgID=%d seq=%d compressor=%d len=%d", id, head.GetMsgId(), head.GetSeq(), head.GetCompressor(), head.GetMsgLen())
+		}
+	}
+}
+
+// MsgID只能使用低24位：超出时封包失败，带有未定义标志位的消息拆包失败，路由不能注册超出范围或系统保留的MsgID
+func TestDataPack_MsgIdLimit(t *testing.T) {
+	dp := NewDataPack()
+	if _, err := dp.Pack(NewMsgPackage(MsgIdMask+1, []byte("x"))); err != ErrMsgIdTooLarge {
+		t.Fatalf("Pack err = %v, want %v", err, ErrMsgIdTooLarge)
+	}
+
+	head := []byte{1, 0, 0, 0, 1, 0, 0, 0x80}
+	if _, err := dp.UnPack(head); err != ErrUnknownMsgFlags {
+		t.Fatalf("UnPack err = %v, want %v", err, ErrUnknownMsgFlags)
+	}
+	if err := dp.UnPackTo(head, &Message{}); err != ErrUnknownMsgFlags {
+		t.Fatalf("UnPackTo err = %v, want %v", err, ErrUnknownMsgFlags)
+	}
+
+	for _, msgID := range []uint32{MsgIdMask + 1, MsgIDResumeToken, MsgIDAck, MsgIDRateLimited} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("AddRouter(%#x) did not panic", msgID)
+				}
+			}()
+			NewMsgHandle().AddRouter(msgID, &BaseRouter{})
+		}()
+	}
+}
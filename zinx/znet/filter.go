@@ -0,0 +1,77 @@
+package znet
+
+import (
+	"io"
+	"src/zinx/ziface"
+)
+
+// 实现过滤器时，先嵌入这个BaseFilter基类，然后根据需要只重写读或写一侧即可
+type BaseFilter struct {
+}
+
+// 包装读取端，默认不做变换
+func (bf *BaseFilter) WrapReader(r io.Reader) io.Reader {
+	return r
+}
+
+// 包装写入端，默认不做变换
+func (bf *BaseFilter) WrapWriter(w io.Writer) io.Writer {
+	return w
+}
+
+// 过滤链写入端需要在每批消息写完之后刷新的Writer
+type flusher interface {
+	Flush() error
+}
+
+// 按顺序用过滤链包装读取端，第0个过滤器最靠近socket
+func buildFilterReader(r io.Reader, filters []ziface.IFilter) io.Reader {
+	for _, filter := range filters {
+		r = filter.WrapReader(r)
+	}
+	return r
+}
+
+// 按顺序用过滤链包装写入端，第0个过滤器最靠近socket
+func buildFilterWriter(w io.Writer, filters []ziface.IFilter) io.Writer {
+	for _, filter := range filters {
+		w = filter.WrapWriter(w)
+	}
+	return w
+}
+
+// 替换链接的过滤链
+// 写入端在此之前已交给Writer的消息(SendMsg已返回)发送完之后生效
+// 读取端在Reader读取下一条消息时生效，握手之后切换过滤链时，应在同步路由(AddSyncRouter)中调用，
+// 保证在读取客户端的下一条消息之前生效
+func (c *Connection) SetFilters(filters ...ziface.IFilter) error {
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+
+	//读取端交给Reader在消息边界上切换
+	c.readFilters.Store(&filters)
+
+	//Writer还没有启动，直接切换写入端，持有startLock期间链接不会启动
+	c.startLock.Lock()
+	if c.GetState() == ConnStateConnecting {
+		c.frameWriter = buildFilterWriter(c.Conn, filters)
+		c.startLock.Unlock()
+		return nil
+	}
+	c.startLock.Unlock()
+	//交给Writer切换，Writer在两批消息之间处理，保证之前的消息仍使用旧的过滤链
+	select {
+	case c.filterChan <- filters:
+		return nil
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	}
+}
+
+// Reader在读取每条消息之前检查是否需要切换读取端的过滤链
+func (c *Connection) applyReadFilters() {
+	if filters := c.readFilters.Swap(nil); filters != nil {
+		c.frameReader = buildFilterReader(c.reader, *filters)
+	}
+}
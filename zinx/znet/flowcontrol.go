@@ -0,0 +1,45 @@
+package znet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 为一条将要交给Worker处理的消息占用一个处理中名额
+// 名额用完时Reader暂停读取socket，直到有消息处理完毕，客户端继续发送会被TCP窗口阻塞
+// 返回false表示等待期间链接已经关闭，只在Reader中调用
+func (c *Connection) acquireInFlight(req *Request) bool {
+	if c.inFlight == nil {
+		return true
+	}
+	select {
+	case c.inFlight <- struct{}{}:
+		req.inFlight = c
+		return true
+	default:
+	}
+
+	//名额已满，暂停读取并记录暂停的次数和时长
+	atomic.AddUint64(&c.readPauses, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&c.readPausedTime, int64(time.Since(start)))
+	}()
+	select {
+	case c.inFlight <- struct{}{}:
+		req.inFlight = c
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// 消息处理完毕，归还处理中名额
+func (c *Connection) releaseInFlight() {
+	<-c.inFlight
+}
+
+// 得到当前正在处理中的消息数
+func (c *Connection) GetInFlight() int {
+	return len(c.inFlight)
+}
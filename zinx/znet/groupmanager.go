@@ -0,0 +1,241 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"src/zinx/ziface"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	//分组不存在
+	ErrGroupNotFound = errors.New("group not found")
+	//分组已存在
+	ErrGroupExists = errors.New("group already exists")
+)
+
+// 可以直接放入已封包消息的链接
+type frameSender interface {
+	isClosed() bool
+	sendBuffRaw(binaryMsg []byte) error
+	trySendBuffRaw(binaryMsg []byte) error
+}
+
+// 判断链接是否已经关闭(或正在关闭)
+// 链接Stop时先置为关闭状态，再退出分组、取消订阅，持有锁检查可以保证不会再加入已经退出的链接
+func connClosed(conn ziface.IConnection) bool {
+	if sender, ok := conn.(frameSender); ok {
+		return sender.isClosed()
+	}
+	return conn.Context().Err() != nil
+}
+
+// 分组管理模块
+type GroupManager struct {
+	connMgr ziface.IConnManager                      //用来根据connID找到链接
+	groups  map[string]map[uint32]ziface.IConnection //分组名 -> 成员集合
+	joined  map[uint32]map[string]struct{}           //connID -> 加入的分组，链接断开时据此退出
+	lock    sync.RWMutex                             //保护分组集合的读写锁
+	dp      *DataPack                                //广播消息使用的封包器
+
+	dropCount uint64 //广播时未能放入成员发送队列的消息数，只能通过原子操作读写
+}
+
+// 创建分组管理模块的方法
+func NewGroupManager(connMgr ziface.IConnManager) *GroupManager {
+	return &GroupManager{
+		connMgr: connMgr,
+		groups:  make(map[string]map[uint32]ziface.IConnection),
+		joined:  make(map[uint32]map[string]struct{}),
+		dp:      NewDataPack(),
+	}
+}
+
+// 创建分组
+func (gm *GroupManager) CreateGroup(name string) error {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	if _, ok := gm.groups[name]; ok {
+		return ErrGroupExists
+	}
+	gm.groups[name] = make(map[uint32]ziface.IConnection)
+	fmt.Println("group", name, "create successfully:group num=", len(gm.groups))
+	return nil
+}
+
+// 销毁分组
+func (gm *GroupManager) DestroyGroup(name string) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	members, ok := gm.groups[name]
+	if !ok {
+		return
+	}
+	for connID := range members {
+		gm.unlink(name, connID)
+	}
+	delete(gm.groups, name)
+	fmt.Println("group", name, "destroy successfully:group num=", len(gm.groups))
+}
+
+// 将链接加入分组
+func (gm *GroupManager) Join(name string, connID uint32) error {
+	conn, err := gm.connMgr.Get(connID)
+	if err != nil {
+		return err
+	}
+
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	members, ok := gm.groups[name]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	//必须在锁内检查，否则可能在链接Stop执行完LeaveAll之后才加入，成员永远不会被移除
+	if connClosed(conn) {
+		return ErrConnClosed
+	}
+	members[connID] = conn
+	if gm.joined[connID] == nil {
+		gm.joined[connID] = make(map[string]struct{})
+	}
+	gm.joined[connID][name] = struct{}{}
+	return nil
+}
+
+// 将链接移出分组
+func (gm *GroupManager) Leave(name string, connID uint32) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	if members, ok := gm.groups[name]; ok {
+		delete(members, connID)
+	}
+	gm.unlink(name, connID)
+}
+
+// 将链接移出它加入的所有分组，链接Stop时自动调用
+func (gm *GroupManager) LeaveAll(connID uint32) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	for name := range gm.joined[connID] {
+		delete(gm.groups[name], connID)
+	}
+	delete(gm.joined, connID)
+}
+
+// 从connID加入的分组中删除分组名，调用者需持有写锁
+func (gm *GroupManager) unlink(name string, connID uint32) {
+	names, ok := gm.joined[connID]
+	if !ok {
+		return
+	}
+	delete(names, name)
+	if len(names) == 0 {
+		delete(gm.joined, connID)
+	}
+}
+
+// 得到分组中所有成员的connID
+func (gm *GroupManager) Members(name string) ([]uint32, error) {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	members, ok := gm.groups[name]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	connIDs := make([]uint32, 0, len(members))
+	for connID := range members {
+		connIDs = append(connIDs, connID)
+	}
+	return connIDs, nil
+}
+
+// 得到链接加入的所有分组
+func (gm *GroupManager) GroupsOf(connID uint32) []string {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	names := make([]string, 0, len(gm.joined[connID]))
+	for name := range gm.joined[connID] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// 得到当前分组总数
+func (gm *GroupManager) Len() int {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	return len(gm.groups)
+}
+
+// 得到广播时因成员发送队列已满等原因未能发出的消息数
+func (gm *GroupManager) GetDropCount() uint64 {
+	return atomic.LoadUint64(&gm.dropCount)
+}
+
+// 向分组中除exclude之外的所有成员发送消息
+// 消息只封包一次，在锁外放入各成员的有缓冲发送队列，队列已满时不等待，丢弃并计入GetDropCount
+// 开启加密时由各链接的Writer分别加密，同一份封包数据不会被修改
+func (gm *GroupManager) Broadcast(name string, msgId uint32, data []byte, exclude ...uint32) error {
+	//只在锁内取出成员快照
+	gm.lock.RLock()
+	members, ok := gm.groups[name]
+	if !ok {
+		gm.lock.RUnlock()
+		return ErrGroupNotFound
+	}
+	conns := make([]ziface.IConnection, 0, len(members))
+	for connID, conn := range members {
+		if !containsConnID(exclude, connID) {
+			conns = append(conns, conn)
+		}
+	}
+	gm.lock.RUnlock()
+
+	if len(conns) == 0 {
+		return nil
+	}
+
+	msg := NewMsgPackage(msgId, data)
+	compressMsg(msg)
+	binaryMsg, err := gm.dp.Pack(msg)
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return err
+	}
+
+	for _, conn := range conns {
+		if sender, ok := conn.(frameSender); ok {
+			if sender.isClosed() {
+				continue
+			}
+			err = sender.trySendBuffRaw(binaryMsg)
+		} else {
+			err = conn.SendBuffMsg(msgId, data)
+		}
+		//单个成员发送失败不影响其他成员
+		if err != nil {
+			atomic.AddUint64(&gm.dropCount, 1)
+			fmt.Println("broadcast to ConnID =", conn.GetConnID(), "err", err)
+		}
+	}
+	return nil
+}
+
+func containsConnID(connIDs []uint32, connID uint32) bool {
+	for _, id := range connIDs {
+		if id == connID {
+			return true
+		}
+	}
+	return false
+}
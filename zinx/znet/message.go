@@ -1,9 +1,43 @@
 package znet
 
+// 消息头中MsgID字段的高8位用作消息标志位，业务的MsgID只能使用低24位
+// 注意：这是对原有协议的不兼容修改，原来MsgID可以使用全部32位
+// 超过MsgIdMask的MsgID在封包时返回ErrMsgIdTooLarge，注册路由时直接panic；
+// 旧客户端发来的高8位不为0的MsgID会被当作标志位解析，未定义的标志位会导致拆包失败
+const (
+	MsgIdMask    uint32 = 0x00FFFFFF
+	msgFlagShift        = 24
+)
+
+// 系统保留的MsgID范围，业务不能为这些MsgID注册路由
+const (
+	msgIDSystemFirst uint32 = 0x00FFFF01 //MsgIDResumeToken
+	msgIDSystemLast  uint32 = 0x00FFFF06 //MsgIDRateLimited
+)
+
+// 消息标志位，置位的标志在消息数据之前附带对应的扩展头
+const (
+	MsgFlagReliable   uint8 = 1 << 0 //可靠消息，扩展头为4字节的序列号
+	MsgFlagCompressed uint8 = 1 << 1 //压缩消息，扩展头为1字节的压缩算法ID，消息的内容为压缩之后的数据
+	MsgFlagEncrypted  uint8 = 1 << 2 //加密消息，扩展头为8字节的nonce计数器，位于其他扩展头之后，消息的内容为密文
+	MsgFlagRequest    uint8 = 1 << 3 //请求消息，扩展头为4字节的请求ID，对端需要用相同的请求ID回复
+	MsgFlagResponse   uint8 = 1 << 4 //回复消息，扩展头为4字节的请求ID，与对应请求消息的请求ID相同
+)
+
+// 所有已定义的标志位，收到其他标志位的消息时拆包失败
+const msgFlagsKnown = MsgFlagReliable | MsgFlagCompressed | MsgFlagEncrypted | MsgFlagRequest | MsgFlagResponse
+
+// 扩展头按以下顺序排列：序列号、请求ID、压缩算法ID、nonce计数器
+
 type Message struct {
 	Id      uint32 //消息的ID
 	DataLen uint32 //消息的长度
 	Data    []byte //消息的内容
+	Flags   uint8  //消息的标志位
+	Seq     uint32 //可靠消息的序列号
+
+	Compressor uint8  //压缩消息使用的压缩算法ID
+	ReqID      uint32 //请求或回复消息的请求ID
 }
 
 // 创建一个Message消息包
@@ -44,3 +78,43 @@ func (m *Message) SetData(data []byte) {
 func (m *Message) SetDataLen(len uint32) {
 	m.DataLen = len
 }
+
+// 获取消息的标志位
+func (m *Message) GetFlags() uint8 {
+	return m.Flags
+}
+
+// 设置消息的标志位
+func (m *Message) SetFlags(flags uint8) {
+	m.Flags = flags
+}
+
+// 获取可靠消息的序列号
+func (m *Message) GetSeq() uint32 {
+	return m.Seq
+}
+
+// 设置可靠消息的序列号
+func (m *Message) SetSeq(seq uint32) {
+	m.Seq = seq
+}
+
+// 获取压缩消息使用的压缩算法ID
+func (m *Message) GetCompressor() uint8 {
+	return m.Compressor
+}
+
+// 设置压缩消息使用的压缩算法ID
+func (m *Message) SetCompressor(id uint8) {
+	m.Compressor = id
+}
+
+// 获取请求或回复消息的请求ID
+func (m *Message) GetReqID() uint32 {
+	return m.ReqID
+}
+
+// 设置请求或回复消息的请求ID
+func (m *Message) SetReqID(id uint32) {
+	m.ReqID = id
+}
@@ -5,6 +5,8 @@ import (
 	"src/zinx/utils"
 	"src/zinx/ziface"
 	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 //消息处理模块的实现
@@ -12,27 +14,50 @@ import (
 type MsgHandle struct {
 	//存放每个MsgID 所对应的处理方法
 	Apis map[uint32]ziface.IRouter
+	//需要在链接的Reader中同步处理的MsgID
+	SyncApis map[uint32]bool
 	//负责Worker取人物的消息队列
 	TaskQueue []chan ziface.IRequest
 	//业务工作Worker池的worker数量
 	WorkerPoolSize uint32
+	//没有开启工作池时，限制同时处理消息的Goroutine数量，为nil时不限制
+	goroutineSem chan struct{}
+	//投递消息时因Worker队列已满或Goroutine数量达到上限而阻塞的次数及累计时长(纳秒)
+	blockedCount uint64
+	blockedTime  int64
 }
 
 // 初始化/创建MsgHandle方法
 func NewMsgHandle() *MsgHandle {
-	return &MsgHandle{
+	mh := &MsgHandle{
 		Apis:           make(map[uint32]ziface.IRouter),
+		SyncApis:       make(map[uint32]bool),
 		WorkerPoolSize: utils.GlobalObject.WorkerPoolSize, //从全局配置中获取
 		TaskQueue:      make([]chan ziface.IRequest, utils.GlobalObject.WorkerPoolSize),
 	}
+	if n := utils.GlobalObject.MaxHandlerGoroutines; n > 0 {
+		mh.goroutineSem = make(chan struct{}, n)
+	}
+	return mh
 }
 
 // 调度/执行对应的Router消息处理方法
 func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
+	//读路径中的Request来自缓冲池，路由处理完毕后归还
+	if req, ok := request.(*Request); ok {
+		defer req.Release()
+	}
+	//定时器等交给Worker执行的函数，不经过路由
+	if req, ok := request.(*funcRequest); ok {
+		req.f()
+		return
+	}
+
 	//1 从Request 中找到msgID
 	handler, ok := mh.Apis[request.GetMsgID()]
 	if !ok {
 		fmt.Println("api MsgID=", request.GetMsgID(), "is NOT FOUND! Need register!")
+		return
 	}
 	//根据MsgID 调度对应router 业务即可
 	handler.PreHandle(request)
@@ -43,6 +68,13 @@ func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
 
 // 为消息添加具体的处理逻辑
 func (mh *MsgHandle) AddRouter(msgID uint32, router ziface.IRouter) {
+	//0 MsgID只能使用低24位，并且不能占用系统保留的MsgID
+	if msgID > MsgIdMask {
+		panic("msgID exceeds 24 bits, msgID = " + strconv.Itoa(int(msgID)))
+	}
+	if msgID >= msgIDSystemFirst && msgID <= msgIDSystemLast {
+		panic("reserved system msgID, msgID = " + strconv.Itoa(int(msgID)))
+	}
 	//1 判断 当前msg绑定的API处理方法是否已经存在
 	if _, ok := mh.Apis[msgID]; ok {
 		//id已经注册
@@ -53,6 +85,18 @@ func (mh *MsgHandle) AddRouter(msgID uint32, router ziface.IRouter) {
 	fmt.Println("Add api MsgID = ", msgID, "success")
 }
 
+// 为消息添加具体的处理逻辑，该消息不进入Worker工作池，而是在链接的Reader中同步处理
+// 用于握手等必须在读取下一条消息之前处理完的业务
+func (mh *MsgHandle) AddSyncRouter(msgID uint32, router ziface.IRouter) {
+	mh.AddRouter(msgID, router)
+	mh.SyncApis[msgID] = true
+}
+
+// 判断消息是否需要在链接的Reader中同步处理
+func (mh *MsgHandle) IsSyncRouter(msgID uint32) bool {
+	return mh.SyncApis[msgID]
+}
+
 // 启动一个Worker工作池(开启工作池的动作只能发生一次，一个zinx框架只能有一个worker工作哦池)
 func (mh *MsgHandle) StartWorkerPool() {
 	//根据workerPoolSize 分别开启Worker，每个worker用一个go来承载
@@ -80,8 +124,14 @@ func (mh *MsgHandle) StartOneWorker(workerId int, taskQueue chan ziface.IRequest
 	}
 }
 
-// 将消息交给Task
+// 将消息交给Task，没有开启工作池时开启一个Goroutine处理
+// Worker队列已满或Goroutine数量达到上限时阻塞调用者(链接的Reader)，直到有空位
 func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
+	if mh.WorkerPoolSize == 0 {
+		mh.goDoMsgHandler(request)
+		return
+	}
+
 	//1 将消息平均分配给不通过的Worker
 	//根据客户端建立的ConnID来进行分配
 	workerID := request.GetConnection().GetConnID() % mh.WorkerPoolSize
@@ -90,6 +140,45 @@ func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
 		"to workerId=", workerID)
 
 	//2 将消息发送给对应worker的TaskQueue即可
+	select {
+	case mh.TaskQueue[workerID] <- request:
+		return
+	default:
+	}
+	start := mh.beginBlocked()
 	mh.TaskQueue[workerID] <- request
+	mh.endBlocked(start)
+}
+
+// 开启一个Goroutine处理消息，数量达到MaxHandlerGoroutines时等待其他Goroutine处理完毕
+func (mh *MsgHandle) goDoMsgHandler(request ziface.IRequest) {
+	if mh.goroutineSem == nil {
+		go mh.DoMsgHandler(request)
+		return
+	}
+	select {
+	case mh.goroutineSem <- struct{}{}:
+	default:
+		start := mh.beginBlocked()
+		mh.goroutineSem <- struct{}{}
+		mh.endBlocked(start)
+	}
+	go func() {
+		defer func() { <-mh.goroutineSem }()
+		mh.DoMsgHandler(request)
+	}()
+}
+
+func (mh *MsgHandle) beginBlocked() time.Time {
+	atomic.AddUint64(&mh.blockedCount, 1)
+	return time.Now()
+}
+
+func (mh *MsgHandle) endBlocked(start time.Time) {
+	atomic.AddInt64(&mh.blockedTime, int64(time.Since(start)))
+}
 
+// 得到投递消息时因Worker队列已满或Goroutine数量达到上限而阻塞的次数及累计时长
+func (mh *MsgHandle) GetBlockedStats() (count uint64, total time.Duration) {
+	return atomic.LoadUint64(&mh.blockedCount), time.Duration(atomic.LoadInt64(&mh.blockedTime))
 }
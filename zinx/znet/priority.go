@@ -0,0 +1,129 @@
+package znet
+
+import (
+	"fmt"
+	"src/zinx/utils"
+	"sync/atomic"
+)
+
+// 发送消息的优先级，数值越小越优先
+const (
+	PriorityHigh   uint8 = iota //踢下线通知、心跳、认证回复等需要尽快送达的消息
+	PriorityNormal              //普通消息，与SendMsg、SendBuffMsg相同
+	PriorityLow                 //地图同步等体积大、可以延后的消息
+
+	priorityLevels = 3
+)
+
+// 按优先级发送消息，消息放入对应优先级的有缓冲队列，队列已满时按SendBuffFullPolicy处理
+// Writer总是先发送高优先级队列中的消息，但连续发送PriorityStarvationRatio条之后会让低优先级发送一条
+func (c *Connection) SendMsgWithPriority(priority uint8, msgId uint32, data []byte) error {
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+	if c.dropIfSlow(msgId) {
+		return ErrSlowConsumerDropped
+	}
+	//将data进行封包MsgDataLen/MsgID/Data
+	binaryMsg, err := c.packMsg(NewMsgPackage(msgId, data))
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return err
+	}
+
+	switch priority {
+	case PriorityHigh:
+		return c.sendQueued(c.highChan, binaryMsg)
+	case PriorityNormal:
+		return c.sendQueued(c.msgBuffChan, binaryMsg)
+	default:
+		return c.sendQueued(c.lowChan, binaryMsg)
+	}
+}
+
+// 得到所有发送队列中等待发送的消息数，包括阻塞在无缓冲msgChan上的调用者
+func (c *Connection) sendQueueLen() int {
+	return len(c.highChan) + len(c.msgBuffChan) + len(c.lowChan) + int(atomic.LoadInt32(&c.msgChanWaiters))
+}
+
+// 判断比level低的优先级队列中是否有消息在等待
+func (c *Connection) lowerPending(level int) bool {
+	switch level {
+	case 0:
+		return len(c.msgBuffChan) > 0 || len(c.lowChan) > 0
+	case 1:
+		return len(c.lowChan) > 0
+	default:
+		return false
+	}
+}
+
+// 不阻塞地从level优先级的队列中取出一条消息
+func (c *Connection) tryRecvLevel(level int) ([]byte, bool) {
+	var data []byte
+	switch level {
+	case 0:
+		select {
+		case data = <-c.highChan:
+		default:
+			return nil, false
+		}
+	case 1:
+		select {
+		case data = <-c.msgChan:
+		case data = <-c.msgBuffChan:
+		default:
+			return nil, false
+		}
+	default:
+		select {
+		case data = <-c.lowChan:
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// 不阻塞地按优先级取出下一条要发送的消息，只在Writer中调用
+func (c *Connection) tryNextFrame() ([]byte, bool) {
+	//某个优先级连续发送的消息数达到上限时，先让更低的优先级发送一条
+	if ratio := int(utils.GlobalObject.PriorityStarvationRatio); ratio > 0 {
+		for level := 0; level < priorityLevels-1; level++ {
+			if c.priorityStreak[level] < ratio {
+				continue
+			}
+			for lower := level + 1; lower < priorityLevels; lower++ {
+				if data, ok := c.tryRecvLevel(lower); ok {
+					c.servedPriority(lower)
+					return data, true
+				}
+			}
+			c.priorityStreak[level] = 0
+		}
+	}
+
+	for level := 0; level < priorityLevels; level++ {
+		if data, ok := c.tryRecvLevel(level); ok {
+			c.servedPriority(level)
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// 记录发送了一条level优先级的消息，只在Writer中调用
+// 更高优先级的连续计数清零，本优先级在有更低优先级消息等待时累加
+func (c *Connection) servedPriority(level int) {
+	for higher := 0; higher < level; higher++ {
+		c.priorityStreak[higher] = 0
+	}
+	if level >= priorityLevels-1 {
+		return
+	}
+	if c.lowerPending(level) {
+		c.priorityStreak[level]++
+	} else {
+		c.priorityStreak[level] = 0
+	}
+}
@@ -0,0 +1,291 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"src/zinx/utils"
+	"src/zinx/ziface"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 主题或主题模式不合法
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// 主题模式中的通配段
+const (
+	topicWildcardOne  = "*" //匹配任意一段
+	topicWildcardRest = "#" //匹配之后的零段或多段，只能作为最后一段
+)
+
+// 订阅树的节点，每一层对应主题的一段
+type topicNode struct {
+	children map[string]*topicNode
+	subs     map[uint32]*subscriber //在该节点结束的模式的订阅者
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{
+		children: make(map[string]*topicNode),
+		subs:     make(map[uint32]*subscriber),
+	}
+}
+
+// 一条等待发送给订阅者的消息
+type pubItem struct {
+	msgId     uint32
+	data      []byte
+	binaryMsg []byte //已封包的消息，所有订阅者共享
+}
+
+// 一个订阅了主题的链接
+// 每个订阅者有自己的有界队列和发送Goroutine，慢的订阅者只会丢弃自己的消息，不会阻塞发布者
+type subscriber struct {
+	conn     ziface.IConnection
+	patterns map[string]struct{}
+	queue    chan pubItem
+	exit     chan struct{}
+}
+
+// 将队列中的消息放入链接的有缓冲发送队列
+func (sub *subscriber) run() {
+	for {
+		select {
+		case item := <-sub.queue:
+			var err error
+			if sender, ok := sub.conn.(frameSender); ok {
+				err = sender.sendBuffRaw(item.binaryMsg)
+			} else {
+				err = sub.conn.SendBuffMsg(item.msgId, item.data)
+			}
+			if err != nil {
+				fmt.Println("publish to ConnID =", sub.conn.GetConnID(), "err", err)
+			}
+		case <-sub.exit:
+			return
+		case <-sub.conn.Done():
+			return
+		}
+	}
+}
+
+// 发布订阅模块
+type PubSub struct {
+	connMgr   ziface.IConnManager    //用来根据connID找到链接
+	root      *topicNode             //订阅树
+	subs      map[uint32]*subscriber //connID -> 订阅者
+	lock      sync.RWMutex           //保护订阅树及订阅者集合的读写锁
+	dp        *DataPack              //发布消息使用的封包器
+	dropCount uint64                 //因订阅者队列已满而丢弃的消息数
+}
+
+// 创建发布订阅模块的方法
+func NewPubSub(connMgr ziface.IConnManager) *PubSub {
+	return &PubSub{
+		connMgr: connMgr,
+		root:    newTopicNode(),
+		subs:    make(map[uint32]*subscriber),
+		dp:      NewDataPack(),
+	}
+}
+
+// 将主题模式拆分为段并检查是否合法
+func splitPattern(pattern string) ([]string, error) {
+	segments := strings.Split(pattern, ".")
+	for i, seg := range segments {
+		if seg == "" {
+			return nil, ErrInvalidTopic
+		}
+		if seg == topicWildcardRest && i != len(segments)-1 {
+			return nil, ErrInvalidTopic
+		}
+	}
+	return segments, nil
+}
+
+// 为链接订阅一个主题或主题模式
+func (ps *PubSub) Subscribe(connID uint32, pattern string) error {
+	segments, err := splitPattern(pattern)
+	if err != nil {
+		return err
+	}
+	conn, err := ps.connMgr.Get(connID)
+	if err != nil {
+		return err
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	//必须在锁内检查，否则可能在链接Stop执行完UnsubscribeAll之后才订阅，订阅永远不会被移除
+	if connClosed(conn) {
+		return ErrConnClosed
+	}
+	sub, ok := ps.subs[connID]
+	if !ok {
+		sub = &subscriber{
+			conn:     conn,
+			patterns: make(map[string]struct{}),
+			queue:    make(chan pubItem, utils.GlobalObject.MaxPubSubQueueLen),
+			exit:     make(chan struct{}),
+		}
+		ps.subs[connID] = sub
+		go sub.run()
+	}
+	sub.patterns[pattern] = struct{}{}
+	ps.root.insert(segments, connID, sub)
+	return nil
+}
+
+// 在订阅树中加入一个订阅，调用者需持有写锁
+func (node *topicNode) insert(segments []string, connID uint32, sub *subscriber) {
+	for _, seg := range segments {
+		child, ok := node.children[seg]
+		if !ok {
+			child = newTopicNode()
+			node.children[seg] = child
+		}
+		node = child
+	}
+	node.subs[connID] = sub
+}
+
+// 取消链接的一个订阅
+func (ps *PubSub) Unsubscribe(connID uint32, pattern string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	sub, ok := ps.subs[connID]
+	if !ok {
+		return
+	}
+	if _, ok := sub.patterns[pattern]; !ok {
+		return
+	}
+	ps.remove(connID, pattern)
+	delete(sub.patterns, pattern)
+	if len(sub.patterns) == 0 {
+		delete(ps.subs, connID)
+		close(sub.exit)
+	}
+}
+
+// 取消链接的所有订阅，链接Stop时自动调用
+func (ps *PubSub) UnsubscribeAll(connID uint32) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	sub, ok := ps.subs[connID]
+	if !ok {
+		return
+	}
+	for pattern := range sub.patterns {
+		ps.remove(connID, pattern)
+	}
+	delete(ps.subs, connID)
+	close(sub.exit)
+}
+
+// 从订阅树中删除一个订阅，并剪掉不再有订阅的节点，调用者需持有写锁
+func (ps *PubSub) remove(connID uint32, pattern string) {
+	segments := strings.Split(pattern, ".")
+	path := make([]*topicNode, 0, len(segments)+1)
+	node := ps.root
+	path = append(path, node)
+	for _, seg := range segments {
+		node = node.children[seg]
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+	delete(node.subs, connID)
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		child := path[i+1]
+		if len(child.subs) > 0 || len(child.children) > 0 {
+			break
+		}
+		delete(path[i].children, segments[i])
+	}
+}
+
+// 得到链接的所有订阅
+func (ps *PubSub) Subscriptions(connID uint32) []string {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	sub, ok := ps.subs[connID]
+	if !ok {
+		return nil
+	}
+	patterns := make([]string, 0, len(sub.patterns))
+	for pattern := range sub.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// 收集订阅树中与主题匹配的订阅者，同一个订阅者只收集一次，调用者需持有读锁
+func (node *topicNode) match(segments []string, matched map[uint32]*subscriber) {
+	//#匹配剩余的零段或多段
+	if rest, ok := node.children[topicWildcardRest]; ok {
+		for connID, sub := range rest.subs {
+			matched[connID] = sub
+		}
+	}
+	if len(segments) == 0 {
+		for connID, sub := range node.subs {
+			matched[connID] = sub
+		}
+		return
+	}
+	if child, ok := node.children[segments[0]]; ok {
+		child.match(segments[1:], matched)
+	}
+	if child, ok := node.children[topicWildcardOne]; ok {
+		child.match(segments[1:], matched)
+	}
+}
+
+// 向订阅了该主题的所有链接发送消息
+// 消息只封包一次，放入各订阅者的队列，队列已满时丢弃该订阅者的这条消息
+func (ps *PubSub) Publish(topic string, msgId uint32, data []byte) int {
+	segments := strings.Split(topic, ".")
+
+	//只在锁内匹配订阅者
+	matched := make(map[uint32]*subscriber)
+	ps.lock.RLock()
+	ps.root.match(segments, matched)
+	ps.lock.RUnlock()
+
+	if len(matched) == 0 {
+		return 0
+	}
+
+	msg := NewMsgPackage(msgId, data)
+	compressMsg(msg)
+	binaryMsg, err := ps.dp.Pack(msg)
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return 0
+	}
+
+	item := pubItem{msgId: msgId, data: data, binaryMsg: binaryMsg}
+	n := 0
+	for _, sub := range matched {
+		select {
+		case sub.queue <- item:
+			n++
+		default:
+			atomic.AddUint64(&ps.dropCount, 1)
+		}
+	}
+	return n
+}
+
+// 得到因订阅者队列已满而丢弃的消息总数
+func (ps *PubSub) GetDropCount() uint64 {
+	return atomic.LoadUint64(&ps.dropCount)
+}
@@ -0,0 +1,77 @@
+package znet
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 主题模式的通配符匹配
+func TestPubSub_match(t *testing.T) {
+	ps := NewPubSub(NewConnManager())
+	patterns := map[uint32]string{
+		1: "market.btc.price",
+		2: "market.*.price",
+		3: "market.#",
+		4: "#",
+		5: "chat.*",
+	}
+	for connID, pattern := range patterns {
+		segments, err := splitPattern(pattern)
+		if err != nil {
+			t.Fatalf("splitPattern(%q) err = %v", pattern, err)
+		}
+		ps.root.insert(segments, connID, &subscriber{})
+	}
+
+	cases := map[string][]uint32{
+		"market.btc.price":  {1, 2, 3, 4},
+		"market.eth.price":  {2, 3, 4},
+		"market":            {3, 4},
+		"market.btc.volume": {3, 4},
+		"chat.world":        {4, 5},
+		"chat.world.cn":     {4},
+	}
+	for topic, want := range cases {
+		matched := make(map[uint32]*subscriber)
+		ps.root.match(strings.Split(topic, "."), matched)
+		var got []uint32
+		for connID := range matched {
+			got = append(got, connID)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if len(got) != len(want) {
+			t.Fatalf("match(%q) = %v, want %v", topic, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("match(%q) = %v, want %v", topic, got, want)
+			}
+		}
+	}
+
+	ps.remove(2, "market.*.price")
+	if _, ok := ps.root.children["market"].children["*"]; ok {
+		t.Fatal("empty node not pruned")
+	}
+	for _, pattern := range []string{"", "a..b", "a.#.b"} {
+		if _, err := splitPattern(pattern); err != ErrInvalidTopic {
+			t.Fatalf("splitPattern(%q) err = %v", pattern, err)
+		}
+	}
+}
+
+// 正在关闭的链接不能再订阅，否则Stop中的UnsubscribeAll已经执行过，订阅永远不会被移除
+func TestPubSub_SubscribeClosing(t *testing.T) {
+	connMgr := NewConnManager()
+	ps := NewPubSub(connMgr)
+	c := &Connection{ConnID: 1, state: ConnStateClosing}
+	connMgr.Add(c)
+
+	if err := ps.Subscribe(1, "chat.*"); err != ErrConnClosed {
+		t.Fatalf("Subscribe err = %v, want %v", err, ErrConnClosed)
+	}
+	if len(ps.subs) != 0 {
+		t.Fatalf("closing conn subscribed")
+	}
+}
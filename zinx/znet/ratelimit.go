@@ -0,0 +1,137 @@
+package znet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"src/zinx/utils"
+	"sync/atomic"
+	"time"
+)
+
+// 消息超过速率限制时回复给客户端的系统保留MsgID
+// 数据为 被限制的MsgID(4字节) + 请求ID(4字节，没有时为0)
+const MsgIDRateLimited uint32 = 0x00FFFF06
+
+// 令牌桶，按固定速率补充令牌，取不到令牌时说明超过了速率限制
+// 不是并发安全的，只在单个Goroutine中使用
+type tokenBucket struct {
+	rate   float64   //每秒补充的令牌数
+	burst  float64   //令牌桶容量
+	tokens float64   //当前令牌数
+	last   time.Time //上次补充令牌的时间
+}
+
+func newTokenBucket(limit utils.RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst == 0 {
+		burst = limit.Rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// 按经过的时间补充令牌
+func (tb *tokenBucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
+
+// 取出n个令牌，令牌不足时透支，返回令牌补回所需的等待时间
+func (tb *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	tb.refill(now)
+	tb.tokens -= n
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// 尝试取出n个令牌
+func (tb *tokenBucket) allow(now time.Time, n float64) bool {
+	tb.refill(now)
+	if tb.tokens < n {
+		return false
+	}
+	tb.tokens -= n
+	return true
+}
+
+// 检查收到的消息是否在速率限制之内，只在Reader中调用
+func (c *Connection) allowMsg(msgID uint32) bool {
+	now := time.Now()
+	if limit := utils.GlobalObject.ConnRateLimit; limit.Rate > 0 {
+		if c.connLimiter == nil {
+			c.connLimiter = newTokenBucket(limit)
+		}
+		if !c.connLimiter.allow(now, 1) {
+			return false
+		}
+	}
+	if limit, ok := utils.GlobalObject.MsgRateLimits[msgID]; ok && limit.Rate > 0 {
+		limiter, ok := c.msgLimiters[msgID]
+		if !ok {
+			if c.msgLimiters == nil {
+				c.msgLimiters = make(map[uint32]*tokenBucket)
+			}
+			limiter = newTokenBucket(limit)
+			c.msgLimiters[msgID] = limiter
+		}
+		if !limiter.allow(now, 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// 处理超过速率限制的消息，返回false表示需要断开链接，只在Reader中调用
+func (c *Connection) handleRateLimited(req *Request) bool {
+	defer req.Release()
+
+	atomic.AddUint32(&c.rateViolations, 1)
+	violations := c.recordViolation(time.Now())
+	c.TcpServer.CallOnRateLimit(c, req.GetMsgID(), violations)
+
+	switch utils.GlobalObject.RateLimitPolicy {
+	case utils.RateLimitReply:
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint32(data[0:4], req.GetMsgID())
+		binary.LittleEndian.PutUint32(data[4:8], req.GetReqID())
+		//回复放入有缓冲队列，客户端不读取时也不会阻塞Reader
+		if err := c.SendBuffMsg(MsgIDRateLimited, data); err != nil {
+			fmt.Println("send rate limited msg err:", err)
+		}
+	case utils.RateLimitDisconnect:
+		if violations >= utils.GlobalObject.RateLimitMaxViolations {
+			fmt.Println("too many rate limit violations, stop ConnID =", c.ConnID)
+			return false
+		}
+	}
+	return true
+}
+
+// 记录一次超限，返回最近一段时间内的超限次数
+// 距离上次超限已经超过RateLimitViolationWindow时重新计数，偶尔超限的链接不会因为累计次数被断开
+func (c *Connection) recordViolation(now time.Time) uint32 {
+	window := time.Duration(utils.GlobalObject.RateLimitViolationWindow) * time.Millisecond
+	if window > 0 && now.Sub(c.lastViolation) >= window {
+		c.recentViolations = 0
+	}
+	c.recentViolations++
+	c.lastViolation = now
+	return c.recentViolations
+}
+
+// 得到链接收到的消息超过速率限制的总次数
+func (c *Connection) GetRateLimitViolations() uint32 {
+	return atomic.LoadUint32(&c.rateViolations)
+}
@@ -0,0 +1,109 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"src/zinx/utils"
+	"src/zinx/ziface"
+	"sync"
+)
+
+// 可靠消息使用的系统保留MsgID
+const (
+	MsgIDAck uint32 = 0x00FFFF03 //客户端 -> 服务器：确认已收到的可靠消息，数据为4字节的累计确认序列号
+)
+
+// 可靠消息的重传缓冲已满，消息没有被发送
+var ErrReliableBuffFull = errors.New("reliable retransmit buffer full")
+
+// 链接的可靠消息通道
+// 每条可靠消息带有递增的序列号，发送后保存在重传缓冲中，直到客户端确认
+// 链接断开后重传缓冲随会话一起保存，会话恢复时按序重放
+type reliableChannel struct {
+	lock    sync.Mutex
+	lastSeq uint32                 //最后一条可靠消息的序列号
+	unacked []ziface.ReliableFrame //尚未被客户端确认的可靠消息，按序列号递增排列
+}
+
+// 发送可靠消息 消息会一直保存在重传缓冲中，直到客户端用MsgIDAck确认
+// 同一个链接上的可靠消息按调用顺序发送，客户端应丢弃序列号不大于已处理序列号的重复消息
+func (c *Connection) SendReliableMsg(msgId uint32, data []byte) error {
+	if c.isClosed() {
+		return ErrConnClosed
+	}
+
+	//分配序列号、放入重传缓冲和发送需要在同一把锁内完成，才能保证按序列号顺序发送
+	rc := &c.reliable
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if maxLen := utils.GlobalObject.MaxReliableBuffLen; maxLen > 0 && len(rc.unacked) >= int(maxLen) {
+		return ErrReliableBuffFull
+	}
+
+	msg := NewMsgPackage(msgId, data)
+	msg.Flags = MsgFlagReliable
+	msg.Seq = rc.lastSeq + 1
+	binaryMsg, err := c.packMsg(msg)
+	if err != nil {
+		fmt.Println("pack error msg id=:", msgId, "err:", err)
+		return err
+	}
+	rc.lastSeq = msg.Seq
+	rc.unacked = append(rc.unacked, ziface.ReliableFrame{Seq: msg.Seq, Data: binaryMsg})
+
+	//发送失败时消息仍保留在重传缓冲中，会话恢复后会被重放
+	return c.sendRaw(binaryMsg)
+}
+
+// 处理客户端的确认消息，丢弃序列号不大于确认序列号的可靠消息
+func (c *Connection) handleAck(req *Request) {
+	defer req.Release()
+
+	data := req.GetData()
+	if len(data) < 4 {
+		fmt.Println("invalid ack msg, ConnID =", c.ConnID)
+		return
+	}
+	ack := binary.LittleEndian.Uint32(data)
+
+	rc := &c.reliable
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	n := 0
+	//序列号允许回绕，按差值判断先后
+	for n < len(rc.unacked) && int32(rc.unacked[n].Seq-ack) <= 0 {
+		n++
+	}
+	if n > 0 {
+		rc.unacked = append(rc.unacked[:0], rc.unacked[n:]...)
+	}
+}
+
+// 取出可靠消息通道的状态，链接断开保存会话时使用
+func (rc *reliableChannel) save() (uint32, []ziface.ReliableFrame) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	unacked := rc.unacked
+	rc.unacked = nil
+	return rc.lastSeq, unacked
+}
+
+// 从恢复的会话中接管可靠消息通道，并按序重放尚未确认的消息
+func (c *Connection) restoreReliable(lastSeq uint32, unacked []ziface.ReliableFrame) {
+	rc := &c.reliable
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	rc.lastSeq = lastSeq
+	rc.unacked = unacked
+	for _, frame := range unacked {
+		if err := c.sendRaw(frame.Data); err != nil {
+			fmt.Println("replay reliable msg err:", err, "seq =", frame.Seq)
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package znet
+
+import (
+	"encoding/binary"
+	"src/zinx/utils"
+	"src/zinx/ziface"
+	"testing"
+	"time"
+)
+
+// 构造一个只用于可靠消息测试的链接
+// msgChan与msgBuffChan共用一个有缓冲的channel，可以按放入的顺序取出所有发送的消息
+func newReliableConn() *Connection {
+	queue := make(chan []byte, 16)
+	return &Connection{dp: NewDataPack(), msgChan: queue, msgBuffChan: queue}
+}
+
+// 取出已发送消息的序列号，非可靠消息为0
+func sentSeqs(t *testing.T, c *Connection) []uint32 {
+	t.Helper()
+	var seqs []uint32
+	for len(c.msgChan) > 0 {
+		frame := <-c.msgChan
+		msg, err := c.dp.UnPack(frame[:8])
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.SetData(frame[8:])
+		if err := c.dp.UnPackExt(msg); err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, msg.GetSeq())
+	}
+	return seqs
+}
+
+func equalSeqs(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 序列号按发送顺序递增，重传缓冲达到MaxReliableBuffLen后拒绝发送
+func TestReliable_SendReliableMsg(t *testing.T) {
+	maxLen := utils.GlobalObject.MaxReliableBuffLen
+	defer func() { utils.GlobalObject.MaxReliableBuffLen = maxLen }()
+
+	tests := []struct {
+		name    string
+		maxLen  uint32
+		lastSeq uint32
+		sends   int
+		want    []uint32
+		full    bool
+	}{
+		{"sequence", 0, 0, 3, []uint32{1, 2, 3}, false},
+		{"wraparound", 0, 0xFFFFFFFE, 3, []uint32{0xFFFFFFFF, 0, 1}, false},
+		{"buff full", 2, 0, 3, []uint32{1, 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utils.GlobalObject.MaxReliableBuffLen = tt.maxLen
+			c := newReliableConn()
+			c.reliable.lastSeq = tt.lastSeq
+
+			var err error
+			for i := 0; i < tt.sends; i++ {
+				err = c.SendReliableMsg(1, []byte("data"))
+			}
+			if full := err == ErrReliableBuffFull; full != tt.full {
+				t.Fatalf("last send err = %v", err)
+			}
+			if got := sentSeqs(t, c); !equalSeqs(got, tt.want) {
+				t.Fatalf("sent seqs = %v, want %v", got, tt.want)
+			}
+			if len(c.reliable.unacked) != len(tt.want) {
+				t.Fatalf("unacked = %d, want %d", len(c.reliable.unacked), len(tt.want))
+			}
+		})
+	}
+}
+
+// 累计确认：丢弃序列号不大于确认序列号的消息，序列号回绕时按差值判断先后
+func TestReliable_handleAck(t *testing.T) {
+	unacked := []uint32{0xFFFFFFFE, 0xFFFFFFFF, 0, 1}
+	tests := []struct {
+		name string
+		ack  uint32
+		want []uint32
+	}{
+		{"before all", 0xFFFFFFFD, []uint32{0xFFFFFFFE, 0xFFFFFFFF, 0, 1}},
+		{"before wrap", 0xFFFFFFFE, []uint32{0xFFFFFFFF, 0, 1}},
+		{"at wrap", 0xFFFFFFFF, []uint32{0, 1}},
+		{"after wrap", 0, []uint32{1}},
+		{"all", 1, nil},
+		{"ahead", 5, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newReliableConn()
+			for _, seq := range unacked {
+				c.reliable.unacked = append(c.reliable.unacked, ziface.ReliableFrame{Seq: seq})
+			}
+
+			req := getRequest(c)
+			req.message.Id = MsgIDAck
+			req.message.Data = binary.LittleEndian.AppendUint32(nil, tt.ack)
+			c.handleAck(req)
+
+			var got []uint32
+			for _, frame := range c.reliable.unacked {
+				got = append(got, frame.Seq)
+			}
+			if !equalSeqs(got, tt.want) {
+				t.Fatalf("unacked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 会话恢复时先按序重放未确认的可靠消息，再重新发送断开时队列中的消息，之后的序列号接着原来的递增
+func TestReliable_resumeReplayOrder(t *testing.T) {
+	s := NewServer("test").(*Server)
+	s.ResumeMgr = NewResumeManager(time.Minute)
+
+	//断开前的链接：发送了序列号1~3的可靠消息，客户端确认了1，队列中还有一条普通消息没有发出
+	old := newReliableConn()
+	for i := 0; i < 3; i++ {
+		if err := old.SendReliableMsg(1, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sentSeqs(t, old)
+	req := getRequest(old)
+	req.message.Data = binary.LittleEndian.AppendUint32(nil, 1)
+	old.handleAck(req)
+	pending, err := old.dp.Pack(NewMsgPackage(2, []byte("pending")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastSeq, unacked := old.reliable.save()
+	s.ResumeMgr.Park("token", ziface.ResumableSession{
+		Session: NewSession(),
+		Pending: [][]byte{pending},
+		LastSeq: lastSeq,
+		Unacked: unacked,
+	})
+
+	c := newReliableConn()
+	c.TcpServer = s
+	req = getRequest(c)
+	req.message.Id = MsgIDResume
+	req.message.Data = []byte("token")
+	if !c.handleFirstRequest(req) {
+		t.Fatal("resume not handled")
+	}
+	if err := c.SendReliableMsg(1, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	//重放的2、3，普通消息(序列号0)，新的可靠消息4
+	if got, want := sentSeqs(t, c), []uint32{2, 3, 0, 4}; !equalSeqs(got, want) {
+		t.Fatalf("sent seqs = %v, want %v", got, want)
+	}
+}
@@ -1,12 +1,24 @@
 package znet
 
-import "src/zinx/ziface"
+import (
+	"errors"
+	"math/bits"
+	"src/zinx/ziface"
+	"sync"
+)
 
 type Request struct {
 	//已经和客户端建立好的链接
 	conn ziface.IConnection
 	//客户端请求的数据
 	msg ziface.IMessage
+
+	//读路径中Request自带的消息，msg指向它，避免每条消息都分配一个Message
+	message Message
+	//从缓冲池中取出的消息数据缓冲，Release时归还
+	buf *[]byte
+	//占用了处理中名额的链接，Release时归还名额
+	inFlight *Connection
 }
 
 func (r *Request) GetConnection() ziface.IConnection {
@@ -19,3 +31,124 @@ func (r *Request) GetData() []byte {
 func (r *Request) GetMsgID() uint32 {
 	return r.msg.GetMsgId()
 }
+
+// 得到请求消息的请求ID，客户端没有要求回复时为0
+func (r *Request) GetReqID() uint32 {
+	return r.msg.GetReqID()
+}
+
+// 可以回复请求的链接
+type replier interface {
+	sendReply(msgId uint32, reqId uint32, data []byte) error
+}
+
+// 回复客户端的请求，回复消息带有相同的MsgID及请求ID，发送方式与SendMsg相同
+// 客户端没有要求回复时，等同于用相同的MsgID调用SendMsg
+func (r *Request) Reply(data []byte) error {
+	if r.msg.GetFlags()&MsgFlagRequest != 0 {
+		if conn, ok := r.conn.(replier); ok {
+			return conn.sendReply(r.GetMsgID(), r.GetReqID(), data)
+		}
+	}
+	return r.conn.SendMsg(r.GetMsgID(), data)
+}
+
+// 交给Worker执行的函数，与链接的消息放入同一个任务队列，执行顺序与消息处理串行
+type funcRequest struct {
+	conn ziface.IConnection
+	f    func()
+}
+
+func (r *funcRequest) GetConnection() ziface.IConnection {
+	return r.conn
+}
+
+func (r *funcRequest) GetData() []byte {
+	return nil
+}
+
+func (r *funcRequest) GetMsgID() uint32 {
+	return 0
+}
+
+func (r *funcRequest) GetReqID() uint32 {
+	return 0
+}
+
+func (r *funcRequest) Reply(data []byte) error {
+	return errors.New("func request can not reply")
+}
+
+// 存放可复用Request对象的缓冲池
+var requestPool = sync.Pool{
+	New: func() interface{} {
+		return new(Request)
+	},
+}
+
+// 从缓冲池中取出一个Request，并绑定到链接上
+func getRequest(conn ziface.IConnection) *Request {
+	r := requestPool.Get().(*Request)
+	r.conn = conn
+	r.msg = &r.message
+	return r
+}
+
+// 将Request及其数据缓冲归还到缓冲池中
+// 路由处理完毕后由MsgHandle调用，之后不能再访问该Request及GetData返回的数据
+func (r *Request) Release() {
+	if r.buf != nil {
+		putBuffer(r.buf)
+	}
+	if r.inFlight != nil {
+		r.inFlight.releaseInFlight()
+	}
+	*r = Request{}
+	requestPool.Put(r)
+}
+
+// 数据缓冲池按2的幂分级，最小64字节，最大64K，超出的直接分配不做复用
+const (
+	minBufferShift = 6
+	maxBufferShift = 16
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// 计算长度为n的数据应使用的缓冲池级别，超出最大级别时返回-1
+func bufferClass(n int) int {
+	shift := bits.Len(uint(n - 1))
+	if shift < minBufferShift {
+		shift = minBufferShift
+	}
+	if shift > maxBufferShift {
+		return -1
+	}
+	return shift - minBufferShift
+}
+
+// 从缓冲池中取出一个长度为n的数据缓冲
+func getBuffer(n int) *[]byte {
+	class := bufferClass(n)
+	if class < 0 {
+		buf := make([]byte, n)
+		return &buf
+	}
+	if v := bufferPools[class].Get(); v != nil {
+		buf := v.(*[]byte)
+		*buf = (*buf)[:n]
+		return buf
+	}
+	buf := make([]byte, n, 1<<(class+minBufferShift))
+	return &buf
+}
+
+// 将数据缓冲归还到对应级别的缓冲池中
+func putBuffer(buf *[]byte) {
+	class := bufferClass(cap(*buf))
+	//不是由缓冲池分配的缓冲不做复用
+	if class < 0 || cap(*buf) != 1<<(class+minBufferShift) {
+		return
+	}
+	bufferPools[class].Put(buf)
+}
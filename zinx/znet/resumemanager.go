@@ -0,0 +1,95 @@
+package znet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"src/zinx/ziface"
+	"sync"
+	"time"
+)
+
+// 会话恢复使用的系统保留MsgID
+const (
+	MsgIDResumeToken uint32 = 0x00FFFF01 //服务器 -> 客户端：链接建立后下发会话恢复令牌
+	MsgIDResume      uint32 = 0x00FFFF02 //客户端 -> 服务器：重连后的第一条消息，携带令牌请求恢复会话
+)
+
+// 一个等待恢复的会话
+type parkedSession struct {
+	session ziface.ResumableSession
+	timer   *time.Timer //宽限期到期后丢弃会话的定时器
+}
+
+// 会话恢复管理模块
+type ResumeManager struct {
+	sessions map[string]*parkedSession //令牌 -> 等待恢复的会话
+	lock     sync.Mutex                //保护会话集合的锁
+	grace    time.Duration             //会话保存的宽限期
+}
+
+// 创建会话恢复管理模块的方法
+func NewResumeManager(grace time.Duration) *ResumeManager {
+	return &ResumeManager{
+		sessions: make(map[string]*parkedSession),
+		grace:    grace,
+	}
+}
+
+// 生成一个新的会话恢复令牌
+func (rm *ResumeManager) NewToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// 链接断开时保存会话及未发送的消息，超过宽限期后自动丢弃
+func (rm *ResumeManager) Park(token string, session ziface.ResumableSession) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	//同一个令牌只保留最新的会话
+	if old, ok := rm.sessions[token]; ok {
+		old.timer.Stop()
+	}
+	parked := &parkedSession{session: session}
+	parked.timer = time.AfterFunc(rm.grace, func() {
+		rm.expire(token, parked)
+	})
+	rm.sessions[token] = parked
+	fmt.Println("session parked, pending msg num =", len(session.Pending), "parked num =", len(rm.sessions))
+}
+
+// 根据令牌取回保存的会话，取回后令牌失效
+func (rm *ResumeManager) Resume(token string) (ziface.ResumableSession, bool) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	parked, ok := rm.sessions[token]
+	if !ok {
+		return ziface.ResumableSession{}, false
+	}
+	parked.timer.Stop()
+	delete(rm.sessions, token)
+	return parked.session, true
+}
+
+// 当前保存的会话数量
+func (rm *ResumeManager) Len() int {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	return len(rm.sessions)
+}
+
+// 宽限期到期，丢弃会话
+func (rm *ResumeManager) expire(token string, parked *parkedSession) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	//期间可能已经被恢复或被新的会话替换
+	if rm.sessions[token] == parked {
+		delete(rm.sessions, token)
+	}
+}
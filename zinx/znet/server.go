@@ -5,6 +5,7 @@ import (
 	"net"
 	"src/zinx/utils"
 	"src/zinx/ziface"
+	"time"
 )
 
 // 实体层
@@ -22,10 +23,28 @@ type Server struct {
 	MsgHandler ziface.IMsgHandle
 	//该server的链接管理器
 	ConnMgr ziface.IConnManager
+	//该server的分组(房间)管理器
+	GroupMgr ziface.IGroupManager
+	//该server的发布订阅模块
+	PubSub ziface.IPubSub
+	//该server所有链接共享的定时器时间轮
+	TimerWheel ziface.ITimerWheel
 	//该Server创建链接之后自动调用Hook函数--OnConnStart
 	OnConnStart func(conn ziface.IConnection)
 	//该Server创建链接之后自动调用Hook函数--OnConnStop
 	OnConnStop func(conn ziface.IConnection)
+	//客户端重连并恢复会话之后自动调用Hook函数--OnConnResume
+	OnConnResume func(conn ziface.IConnection)
+	//链接收到的消息超过速率限制时自动调用Hook函数--OnRateLimit
+	OnRateLimit func(conn ziface.IConnection, msgID uint32, violations uint32)
+	//链接被判定为慢速消费者时自动调用Hook函数--OnSlowConsumer
+	OnSlowConsumer func(conn ziface.IConnection, queueLen int, writeLatency time.Duration)
+	//该server的会话恢复管理器，未开启会话恢复时为nil
+	ResumeMgr ziface.IResumeManager
+	//新链接使用的字节流过滤链
+	Filters []ziface.IFilter
+	//所有链接合计的带宽限制
+	bandwidth bandwidthLimiters
 }
 
 // 启动服务器
@@ -37,9 +56,17 @@ func (s *Server) Start() {
 		utils.GlobalObject.MaxConn,
 		utils.GlobalObject.MaxPacketSize)
 
+	//配置的压缩算法必须在启动前注册，否则消息会悄悄地以不压缩的方式发送
+	if name := utils.GlobalObject.Compression; name != "" {
+		if _, ok := GetCompressorByName(name); !ok {
+			panic("[Zinx] Compression " + name + " is not registered")
+		}
+	}
+
 	go func() {
-		//0 开启消息队列及Worker工作池
+		//0 开启消息队列及Worker工作池，启动定时器时间轮
 		s.MsgHandler.StartWorkerPool()
+		s.TimerWheel.Start()
 
 		//1 获取一个TCP的addr
 		addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
@@ -47,8 +74,8 @@ func (s *Server) Start() {
 			fmt.Println("resolve tcp addr error", err)
 			return
 		}
-		//2 监听服务器的地址
-		listenner, err := net.ListenTCP(s.IPVersion, addr)
+		//2 监听服务器的地址，按配置设置监听socket的选项
+		listenner, err := listenTCP(s.IPVersion, addr.String())
 		if err != nil {
 			fmt.Println("listen", s.IPVersion, "err", err)
 			return
@@ -74,6 +101,9 @@ func (s *Server) Start() {
 				continue
 			}
 
+			//按配置设置链接的socket选项
+			applyConnOptions(conn)
+
 			//将处理新链接的业务方法 和conn 进行绑定 得到我们的链接模块
 			dealConn := NewConnection(s, conn, cid, s.MsgHandler)
 			cid++
@@ -91,6 +121,7 @@ func (s *Server) Stop() {
 	//将一些服务器的资源、状态或者一些已经开辟的链接信息 进行停止或者回收
 	fmt.Println("[STOP] Zinx Server name", s.Name)
 	s.ConnMgr.ClearConn()
+	s.TimerWheel.Stop()
 
 }
 func (s *Server) Serve() {
@@ -108,10 +139,42 @@ func (s *Server) AddRouter(msgID uint32, router ziface.IRouter) {
 	fmt.Println("Add Router Success! ")
 }
 
+// 路由功能：给当前的服务注册一个同步路由，在链接的Reader中直接执行
+func (s *Server) AddSyncRouter(msgID uint32, router ziface.IRouter) {
+	s.MsgHandler.AddSyncRouter(msgID, router)
+	fmt.Println("Add Sync Router Success! ")
+}
+
+// 为之后建立的链接追加一个字节流过滤器
+func (s *Server) AddFilter(filter ziface.IFilter) {
+	s.Filters = append(s.Filters, filter)
+}
+
+// 获取新链接使用的过滤链
+func (s *Server) GetFilters() []ziface.IFilter {
+	return s.Filters
+}
+
 func (s *Server) GetConnMgr() ziface.IConnManager {
 	return s.ConnMgr
 }
 
+func (s *Server) GetGroupMgr() ziface.IGroupManager {
+	return s.GroupMgr
+}
+
+func (s *Server) GetPubSub() ziface.IPubSub {
+	return s.PubSub
+}
+
+func (s *Server) GetTimerWheel() ziface.ITimerWheel {
+	return s.TimerWheel
+}
+
+func (s *Server) GetResumeMgr() ziface.IResumeManager {
+	return s.ResumeMgr
+}
+
 // 初始化Server模块的方法
 func NewServer(name string) ziface.IServer {
 	s := &Server{
@@ -122,6 +185,20 @@ func NewServer(name string) ziface.IServer {
 		MsgHandler: NewMsgHandle(),
 		ConnMgr:    NewConnManager(),
 	}
+	s.GroupMgr = NewGroupManager(s.ConnMgr)
+	s.PubSub = NewPubSub(s.ConnMgr)
+	s.bandwidth.send = newBandwidthLimiter(utils.GlobalObject.ServerMaxSendBytesPerSec)
+	s.bandwidth.recv = newBandwidthLimiter(utils.GlobalObject.ServerMaxRecvBytesPerSec)
+	s.TimerWheel = NewTimingWheel(time.Duration(utils.GlobalObject.TimerTick) * time.Millisecond)
+	if utils.GlobalObject.SessionResumeGrace > 0 {
+		s.ResumeMgr = NewResumeManager(time.Duration(utils.GlobalObject.SessionResumeGrace) * time.Second)
+	}
+	//开启加密必须配置签名私钥，否则客户端无法验证服务器身份
+	if utils.GlobalObject.EnableEncryption {
+		if _, err := serverSignKey(); err != nil {
+			panic(err)
+		}
+	}
 	return s
 }
 
@@ -150,3 +227,41 @@ func (s *Server) CallOnConnStop(conn ziface.IConnection) {
 		s.OnConnStop(conn)
 	}
 }
+
+// 注册OnConnResume钩子函数
+func (s *Server) SetOnConnResume(hookFunc func(connection ziface.IConnection)) {
+	s.OnConnResume = hookFunc
+}
+
+// 调用OnConnResume钩子函数
+func (s *Server) CallOnConnResume(conn ziface.IConnection) {
+	if s.OnConnResume != nil {
+		fmt.Println("----> Call OnConnResume() ")
+		s.OnConnResume(conn)
+	}
+}
+
+// 注册OnRateLimit钩子函数
+func (s *Server) SetOnRateLimit(hookFunc func(connection ziface.IConnection, msgID uint32, violations uint32)) {
+	s.OnRateLimit = hookFunc
+}
+
+// 调用OnRateLimit钩子函数
+func (s *Server) CallOnRateLimit(conn ziface.IConnection, msgID uint32, violations uint32) {
+	if s.OnRateLimit != nil {
+		s.OnRateLimit(conn, msgID, violations)
+	}
+}
+
+// 注册OnSlowConsumer钩子函数
+func (s *Server) SetOnSlowConsumer(hookFunc func(connection ziface.IConnection, queueLen int, writeLatency time.Duration)) {
+	s.OnSlowConsumer = hookFunc
+}
+
+// 调用OnSlowConsumer钩子函数
+func (s *Server) CallOnSlowConsumer(conn ziface.IConnection, queueLen int, writeLatency time.Duration) {
+	if s.OnSlowConsumer != nil {
+		fmt.Println("----> Call OnSlowConsumer() ")
+		s.OnSlowConsumer(conn, queueLen, writeLatency)
+	}
+}
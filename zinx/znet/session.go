@@ -0,0 +1,247 @@
+package znet
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"src/zinx/ziface"
+	"sync"
+	"time"
+)
+
+var (
+	//会话中不存在该属性(或已过期)
+	ErrPropertyNotFound = errors.New("no property found")
+	//会话属性的类型与期望的类型不一致
+	ErrPropertyTypeMismatch = errors.New("property type mismatch")
+)
+
+// 会话中的一个属性
+type sessionEntry struct {
+	value    interface{}
+	expireAt time.Time //零值表示永不过期
+}
+
+// 判断属性在now时刻是否已经过期
+func (e *sessionEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// 会话存储模块
+type Session struct {
+	entries map[string]*sessionEntry
+	lock    sync.RWMutex
+
+	hooks     []ziface.SessionHook
+	hooksLock sync.RWMutex
+}
+
+// 一次属性变更，在释放锁之后再通知钩子函数
+type sessionChange struct {
+	key      string
+	oldValue interface{}
+	newValue interface{}
+}
+
+// 创建会话存储的方法
+func NewSession() *Session {
+	return &Session{
+		entries: make(map[string]*sessionEntry),
+	}
+}
+
+// 获取会话属性
+func (s *Session) Get(key string) (interface{}, error) {
+	s.lock.RLock()
+	entry, ok := s.entries[key]
+	s.lock.RUnlock()
+	if !ok {
+		return nil, ErrPropertyNotFound
+	}
+	if entry.expired(time.Now()) {
+		s.expire(key)
+		return nil, ErrPropertyNotFound
+	}
+	return entry.value, nil
+}
+
+// 设置会话属性
+func (s *Session) Set(key string, value interface{}) {
+	s.SetWithTTL(key, value, 0)
+}
+
+// 设置会话属性，超过ttl之后自动失效，ttl<=0表示永不过期
+func (s *Session) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	entry := &sessionEntry{value: value}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+
+	s.lock.Lock()
+	old, _, expired := s.lookup(key, time.Now())
+	s.entries[key] = entry
+	s.lock.Unlock()
+
+	s.notifyExpired(expired)
+	s.notify(sessionChange{key: key, oldValue: old, newValue: value})
+}
+
+// 移除会话属性
+func (s *Session) Remove(key string) {
+	s.lock.Lock()
+	old, ok, expired := s.lookup(key, time.Now())
+	delete(s.entries, key)
+	s.lock.Unlock()
+
+	s.notifyExpired(expired)
+	if ok {
+		s.notify(sessionChange{key: key, oldValue: old})
+	}
+}
+
+// 当属性当前值等于old时替换为new，返回是否替换成功，old为nil表示属性不存在时才设置
+// 不可比较的类型(slice、map等)永远不会相等
+func (s *Session) CompareAndSwap(key string, old, new interface{}) bool {
+	s.lock.Lock()
+	cur, ok, expired := s.lookup(key, time.Now())
+	if (old == nil && ok) || (old != nil && (!ok || !equalValue(cur, old))) {
+		s.lock.Unlock()
+		s.notifyExpired(expired)
+		return false
+	}
+	//保留原属性的过期时间
+	entry := &sessionEntry{value: new}
+	if ok {
+		entry.expireAt = s.entries[key].expireAt
+	}
+	s.entries[key] = entry
+	s.lock.Unlock()
+
+	s.notifyExpired(expired)
+	s.notify(sessionChange{key: key, oldValue: cur, newValue: new})
+	return true
+}
+
+// 原子地读取并更新属性，fn返回keep=false时删除该属性，返回更新后的值
+// fn在持有写锁时被调用，不能在fn中再访问当前会话
+func (s *Session) Update(key string, fn func(old interface{}, exists bool) (value interface{}, keep bool)) interface{} {
+	s.lock.Lock()
+	old, ok, expired := s.lookup(key, time.Now())
+	value, keep := fn(old, ok)
+	if keep {
+		entry := &sessionEntry{value: value}
+		if ok {
+			entry.expireAt = s.entries[key].expireAt
+		}
+		s.entries[key] = entry
+	} else {
+		delete(s.entries, key)
+		value = nil
+	}
+	s.lock.Unlock()
+
+	s.notifyExpired(expired)
+	if keep || ok {
+		s.notify(sessionChange{key: key, oldValue: old, newValue: value})
+	}
+	return value
+}
+
+// 注册属性变更的钩子函数，新增、修改、删除及过期时都会被调用
+func (s *Session) OnChange(hook ziface.SessionHook) {
+	s.hooksLock.Lock()
+	defer s.hooksLock.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// 获取当前所有未过期属性的快照
+func (s *Session) Snapshot() map[string]interface{} {
+	now := time.Now()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	snapshot := make(map[string]interface{}, len(s.entries))
+	for key, entry := range s.entries {
+		if !entry.expired(now) {
+			snapshot[key] = entry.value
+		}
+	}
+	return snapshot
+}
+
+// 将当前所有未过期属性序列化为JSON
+func (s *Session) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+// 在持有写锁时查找未过期的属性，已过期的属性会被删除
+// 删除了过期属性时返回对应的变更，调用者释放锁之后通过notifyExpired通知钩子函数
+func (s *Session) lookup(key string, now time.Time) (interface{}, bool, *sessionChange) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if entry.expired(now) {
+		delete(s.entries, key)
+		return nil, false, &sessionChange{key: key, oldValue: entry.value}
+	}
+	return entry.value, true, nil
+}
+
+// 通知lookup中删除的过期属性
+func (s *Session) notifyExpired(change *sessionChange) {
+	if change != nil {
+		s.notify(*change)
+	}
+}
+
+// 删除一个已经过期的属性，并通知钩子函数
+func (s *Session) expire(key string) {
+	s.lock.Lock()
+	entry, ok := s.entries[key]
+	//加写锁之前可能已经被重新设置
+	if !ok || !entry.expired(time.Now()) {
+		s.lock.Unlock()
+		return
+	}
+	delete(s.entries, key)
+	s.lock.Unlock()
+
+	s.notify(sessionChange{key: key, oldValue: entry.value})
+}
+
+// 调用所有注册的钩子函数
+func (s *Session) notify(change sessionChange) {
+	s.hooksLock.RLock()
+	hooks := s.hooks
+	s.hooksLock.RUnlock()
+
+	for _, hook := range hooks {
+		hook(change.key, change.oldValue, change.newValue)
+	}
+}
+
+// 判断两个属性值是否相等，不可比较的类型永远不相等
+func equalValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// 按类型T获取会话属性
+func GetAs[T any](s ziface.ISession, key string) (T, error) {
+	var zero T
+	value, err := s.Get(key)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := value.(T)
+	if !ok {
+		return zero, ErrPropertyTypeMismatch
+	}
+	return typed, nil
+}
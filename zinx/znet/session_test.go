@@ -0,0 +1,82 @@
+package znet
+
+import (
+	"testing"
+	"time"
+)
+
+// 会话属性的类型化读取、过期、原子更新及变更通知
+func TestSession(t *testing.T) {
+	s := NewSession()
+
+	var changes []string
+	s.OnChange(func(key string, oldValue, newValue interface{}) {
+		changes = append(changes, key)
+	})
+
+	s.Set("Name", "Aceld")
+	if name, err := GetAs[string](s, "Name"); err != nil || name != "Aceld" {
+		t.Fatalf("GetAs Name = %q, %v", name, err)
+	}
+	if _, err := GetAs[int](s, "Name"); err != ErrPropertyTypeMismatch {
+		t.Fatalf("GetAs[int] err = %v", err)
+	}
+
+	s.SetWithTTL("Token", "abc", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := s.Get("Token"); err != ErrPropertyNotFound {
+		t.Fatalf("expired Token err = %v", err)
+	}
+
+	if !s.CompareAndSwap("Level", nil, 1) || s.CompareAndSwap("Level", nil, 2) {
+		t.Fatal("CompareAndSwap on missing key")
+	}
+	if !s.CompareAndSwap("Level", 1, 2) || s.CompareAndSwap("Level", 1, 3) {
+		t.Fatal("CompareAndSwap on existing key")
+	}
+	s.Update("Level", func(old interface{}, exists bool) (interface{}, bool) {
+		return old.(int) + 1, true
+	})
+	if level, _ := GetAs[int](s, "Level"); level != 3 {
+		t.Fatalf("Level = %d", level)
+	}
+
+	data, err := s.MarshalJSON()
+	if err != nil || string(data) != `{"Level":3,"Name":"Aceld"}` {
+		t.Fatalf("MarshalJSON = %s, %v", data, err)
+	}
+	//Name Token Token(过期) Level Level Update
+	if len(changes) != 6 {
+		t.Fatalf("changes = %v", changes)
+	}
+}
+
+// 写操作中遇到的过期属性同样会通知钩子函数
+func TestSession_expireOnWrite(t *testing.T) {
+	writes := map[string]func(s *Session){
+		"Set":    func(s *Session) { s.Set("Token", "def") },
+		"Remove": func(s *Session) { s.Remove("Token") },
+		"CAS":    func(s *Session) { s.CompareAndSwap("Token", "abc", "def") },
+		"Update": func(s *Session) {
+			s.Update("Token", func(old interface{}, exists bool) (interface{}, bool) { return nil, false })
+		},
+	}
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			s := NewSession()
+			s.SetWithTTL("Token", "abc", time.Millisecond)
+			time.Sleep(2 * time.Millisecond)
+
+			expired := 0
+			s.OnChange(func(key string, oldValue, newValue interface{}) {
+				if oldValue == "abc" && newValue == nil {
+					expired++
+				}
+			})
+			write(s)
+			if expired != 1 {
+				t.Fatalf("expired notified %d times", expired)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"src/zinx/utils"
+	"sync/atomic"
+	"time"
+)
+
+// 链接被判定为慢速消费者，非关键消息被丢弃
+var ErrSlowConsumerDropped = errors.New("slow consumer, non-critical msg dropped")
+
+// 根据发送队列长度及最近一次写入耗时检查链接是否为慢速消费者
+// 发送者和Writer都会调用，只在状态从正常变为慢速时调用OnSlowConsumer并执行策略
+// 返回true表示链接已经因此被断开(在新的Goroutine中异步关闭)
+func (c *Connection) checkSlowConsumer(queueLen int, writeLatency time.Duration) bool {
+	maxQueueLen := int(utils.GlobalObject.SlowConsumerQueueLen)
+	maxLatency := time.Duration(utils.GlobalObject.SlowConsumerWriteLatency) * time.Millisecond
+	if !(maxQueueLen > 0 && queueLen >= maxQueueLen) && !(maxLatency > 0 && writeLatency >= maxLatency) {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&c.slow, 0, 1) {
+		return false
+	}
+
+	atomic.AddUint64(&c.slowCount, 1)
+	fmt.Println("slow consumer ConnID =", c.ConnID, "queue len =", queueLen, "write latency =", writeLatency)
+	c.TcpServer.CallOnSlowConsumer(c, queueLen, writeLatency)
+
+	//调用者可能是持有锁的发送者(例如SendReliableMsg)，Stop需要的锁及OnConnStop钩子都不能在这里同步执行
+	if utils.GlobalObject.SlowConsumerPolicy == utils.SlowConsumerDisconnect {
+		go c.Stop()
+		return true
+	}
+	return false
+}
+
+// Writer发送完一批消息后，队列降到阈值的一半以下且写入耗时恢复正常时解除慢速状态
+func (c *Connection) recoverSlowConsumer(queueLen int, writeLatency time.Duration) {
+	if atomic.LoadInt32(&c.slow) == 0 {
+		return
+	}
+	maxLatency := time.Duration(utils.GlobalObject.SlowConsumerWriteLatency) * time.Millisecond
+	if queueLen > int(utils.GlobalObject.SlowConsumerQueueLen)/2 || (maxLatency > 0 && writeLatency >= maxLatency) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&c.slow, 1, 0) {
+		fmt.Println("slow consumer recovered ConnID =", c.ConnID)
+	}
+}
+
+// Writer开始一次写入：记录开始时间，写入阻塞超过SlowConsumerWriteLatency时由看门狗判定为慢速消费者
+// 不必等到写入返回，客户端完全不读取时也能及时发现
+func (c *Connection) beginWrite(start time.Time) {
+	atomic.StoreInt64(&c.writeStart, start.UnixNano())
+	maxLatency := time.Duration(utils.GlobalObject.SlowConsumerWriteLatency) * time.Millisecond
+	if maxLatency <= 0 {
+		return
+	}
+	if c.writeWatchdog == nil {
+		c.writeWatchdog = time.AfterFunc(maxLatency, c.checkBlockedWrite)
+	} else {
+		c.writeWatchdog.Reset(maxLatency)
+	}
+}
+
+// Writer结束一次写入
+func (c *Connection) endWrite() {
+	atomic.StoreInt64(&c.writeStart, 0)
+	if c.writeWatchdog != nil {
+		c.writeWatchdog.Stop()
+	}
+}
+
+// 得到Writer正在进行的写入已经持续的时间，没有写入时为0
+func (c *Connection) writeInProgress() time.Duration {
+	start := atomic.LoadInt64(&c.writeStart)
+	if start == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, start))
+}
+
+// 看门狗到期时写入仍未返回，按慢速消费者检查
+func (c *Connection) checkBlockedWrite() {
+	if latency := c.writeInProgress(); latency > 0 {
+		c.checkSlowConsumer(c.sendQueueLen(), latency)
+	}
+}
+
+// 链接处于慢速状态且策略为drop时，丢弃属于非关键类别的消息
+func (c *Connection) dropIfSlow(msgId uint32) bool {
+	if atomic.LoadInt32(&c.slow) == 0 || utils.GlobalObject.SlowConsumerPolicy != utils.SlowConsumerDrop {
+		return false
+	}
+	for _, id := range utils.GlobalObject.NonCriticalMsgIDs {
+		if id == msgId {
+			atomic.AddUint64(&c.slowDropCount, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// 从已封包的消息中取出MsgID
+func frameMsgID(binaryMsg []byte) uint32 {
+	return binary.LittleEndian.Uint32(binaryMsg[4:8]) & MsgIdMask
+}
+
+// 判断链接当前是否被判定为慢速消费者
+func (c *Connection) IsSlowConsumer() bool {
+	return atomic.LoadInt32(&c.slow) == 1
+}
@@ -0,0 +1,92 @@
+package znet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"src/zinx/utils"
+	"syscall"
+	"time"
+)
+
+// 在accept之后、NewConnection之前按配置设置链接的socket选项
+// 单个选项设置失败只打印日志，不影响链接的建立
+func applyConnOptions(conn *net.TCPConn) {
+	g := utils.GlobalObject
+
+	if err := conn.SetNoDelay(g.TCPNoDelay); err != nil {
+		fmt.Println("set TCP_NODELAY err:", err)
+	}
+	if err := conn.SetKeepAlive(g.TCPKeepAlive); err != nil {
+		fmt.Println("set SO_KEEPALIVE err:", err)
+	}
+	if g.TCPKeepAlive && g.TCPKeepAlivePeriod > 0 {
+		if err := conn.SetKeepAlivePeriod(time.Duration(g.TCPKeepAlivePeriod) * time.Second); err != nil {
+			fmt.Println("set keepalive period err:", err)
+		}
+	}
+	if g.SocketRecvBuffer > 0 {
+		if err := conn.SetReadBuffer(g.SocketRecvBuffer); err != nil {
+			fmt.Println("set SO_RCVBUF err:", err)
+		}
+	}
+	if g.SocketSendBuffer > 0 {
+		if err := conn.SetWriteBuffer(g.SocketSendBuffer); err != nil {
+			fmt.Println("set SO_SNDBUF err:", err)
+		}
+	}
+	if g.SocketLinger >= 0 {
+		if err := conn.SetLinger(g.SocketLinger); err != nil {
+			fmt.Println("set SO_LINGER err:", err)
+		}
+	}
+	if g.TCPUserTimeout > 0 {
+		if err := controlConn(conn, setUserTimeout); err != nil {
+			fmt.Println("set TCP_USER_TIMEOUT err:", err)
+		}
+	}
+}
+
+// 在socket的文件描述符上执行设置函数
+func controlConn(conn syscall.Conn, set func(fd uintptr) error) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := raw.Control(func(fd uintptr) {
+		setErr = set(fd)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
+
+// 按配置创建监听socket，TCP_DEFER_ACCEPT、TCP_FASTOPEN在bind之前设置，backlog在listen之后调整
+func listenTCP(network string, addr string) (*net.TCPListener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var setErr error
+			if err := c.Control(func(fd uintptr) {
+				setErr = setListenerOptions(fd)
+			}); err != nil {
+				return err
+			}
+			return setErr
+		},
+	}
+	listener, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener := listener.(*net.TCPListener)
+
+	if backlog := utils.GlobalObject.ListenBacklog; backlog > 0 {
+		if err := controlConn(tcpListener, func(fd uintptr) error {
+			return setBacklog(fd, backlog)
+		}); err != nil {
+			fmt.Println("set listen backlog err:", err)
+		}
+	}
+	return tcpListener, nil
+}
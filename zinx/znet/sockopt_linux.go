@@ -0,0 +1,40 @@
+//go:build linux
+
+package znet
+
+import (
+	"fmt"
+	"src/zinx/utils"
+	"syscall"
+)
+
+// syscall包中没有定义的Linux socket选项
+const (
+	tcpUserTimeout = 0x12 //TCP_USER_TIMEOUT
+	tcpFastOpen    = 0x17 //TCP_FASTOPEN
+)
+
+// 设置TCP_USER_TIMEOUT，已发送的数据超过该时间未被确认时内核断开链接
+func setUserTimeout(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, utils.GlobalObject.TCPUserTimeout)
+}
+
+// 设置监听socket的TCP_DEFER_ACCEPT、TCP_FASTOPEN
+func setListenerOptions(fd uintptr) error {
+	if seconds := utils.GlobalObject.TCPDeferAccept; seconds > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, seconds); err != nil {
+			return fmt.Errorf("set TCP_DEFER_ACCEPT: %w", err)
+		}
+	}
+	if qlen := utils.GlobalObject.TCPFastOpen; qlen > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, qlen); err != nil {
+			return fmt.Errorf("set TCP_FASTOPEN: %w", err)
+		}
+	}
+	return nil
+}
+
+// 对已经在监听的socket再次调用listen，Linux会使用新的backlog(仍受somaxconn限制)
+func setBacklog(fd uintptr, backlog int) error {
+	return syscall.Listen(int(fd), backlog)
+}
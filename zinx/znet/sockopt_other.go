@@ -0,0 +1,28 @@
+//go:build !linux
+
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"src/zinx/utils"
+)
+
+// 当前系统不支持的socket选项
+var errSockoptUnsupported = errors.New("socket option not supported on this platform")
+
+func setUserTimeout(fd uintptr) error {
+	return errSockoptUnsupported
+}
+
+// TCP_DEFER_ACCEPT、TCP_FASTOPEN只在Linux上生效，其他系统上忽略
+func setListenerOptions(fd uintptr) error {
+	if utils.GlobalObject.TCPDeferAccept > 0 || utils.GlobalObject.TCPFastOpen > 0 {
+		fmt.Println("TCP_DEFER_ACCEPT/TCP_FASTOPEN ignored:", errSockoptUnsupported)
+	}
+	return nil
+}
+
+func setBacklog(fd uintptr, backlog int) error {
+	return errSockoptUnsupported
+}
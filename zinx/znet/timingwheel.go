@@ -0,0 +1,251 @@
+package znet
+
+import (
+	"src/zinx/utils"
+	"src/zinx/ziface"
+	"sync"
+	"time"
+)
+
+// 分层时间轮每层64个槽，共4层
+// 以10ms为一格时，各层的跨度分别约为 640ms、41s、43分钟、46小时，超出最高层跨度的定时器到期前会重新放入
+const (
+	timerSlotBits = 6
+	timerSlots    = 1 << timerSlotBits
+	timerSlotMask = timerSlots - 1
+	timerLevels   = 4
+)
+
+// 时间轮中的一个定时器
+type Timer struct {
+	wheel  *TimingWheel
+	connID uint32
+	expire uint64 //到期时的格数
+	f      func()
+	slot   map[*Timer]struct{} //定时器所在的槽，已执行或已取消时为nil
+}
+
+// 取消定时器
+func (t *Timer) Stop() bool {
+	tw := t.wheel
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if t.slot == nil {
+		return false
+	}
+	tw.remove(t)
+	return true
+}
+
+// 分层时间轮
+// 定时器按到期时间放入不同层的槽中，低层的槽转完一圈时把高层对应槽中的定时器下放到低层
+// 添加、取消定时器都是O(1)的，大量链接的心跳、超时不需要各自创建time.Timer
+type TimingWheel struct {
+	tick      time.Duration
+	start     time.Time //时间轮启动的时间，用来根据实际经过的时间推进，避免误差累积
+	now       uint64    //当前已经走过的格数
+	slots     [timerLevels][timerSlots]map[*Timer]struct{}
+	conns     map[uint32]map[*Timer]struct{} //connID -> 链接的定时器
+	lock      sync.Mutex
+	exitChan  chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// 创建时间轮的方法，tick为每一格的时间，也是定时器的精度
+func NewTimingWheel(tick time.Duration) *TimingWheel {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+	tw := &TimingWheel{
+		tick:     tick,
+		start:    time.Now(),
+		conns:    make(map[uint32]map[*Timer]struct{}),
+		exitChan: make(chan struct{}),
+	}
+	for level := range tw.slots {
+		for i := range tw.slots[level] {
+			tw.slots[level][i] = make(map[*Timer]struct{})
+		}
+	}
+	return tw
+}
+
+// 启动时间轮
+func (tw *TimingWheel) Start() {
+	tw.startOnce.Do(func() {
+		go tw.run()
+	})
+}
+
+// 停止时间轮
+func (tw *TimingWheel) Stop() {
+	tw.stopOnce.Do(func() {
+		close(tw.exitChan)
+	})
+}
+
+func (tw *TimingWheel) run() {
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tw.advanceTo(uint64(time.Since(tw.start) / tw.tick))
+		case <-tw.exitChan:
+			return
+		}
+	}
+}
+
+// 为链接添加一个定时器
+func (tw *TimingWheel) AfterFunc(connID uint32, d time.Duration, f func()) ziface.ITimer {
+	return tw.afterFunc(connID, d, f, nil)
+}
+
+// 为链接添加一个定时器，closed不为nil时在锁内检查链接是否已经关闭
+// 链接Stop时先置为关闭状态再调用CancelConn，锁内检查保证不会给已经取消过定时器的链接再添加定时器
+// 链接已关闭时返回一个已经停止的定时器
+func (tw *TimingWheel) afterFunc(connID uint32, d time.Duration, f func(), closed func() bool) *Timer {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	t := &Timer{
+		wheel:  tw,
+		connID: connID,
+		f:      f,
+	}
+	if closed != nil && closed() {
+		return t
+	}
+
+	//tw.now只在ticker触发时推进，会落后于实际时间，到期时间按实际经过的时间计算
+	//不足一格的按一格计算，保证不会提前执行
+	t.expire = uint64((time.Since(tw.start) + d + tw.tick - 1) / tw.tick)
+	if t.expire <= tw.now {
+		t.expire = tw.now + 1
+	}
+	tw.add(t)
+
+	if tw.conns[connID] == nil {
+		tw.conns[connID] = make(map[*Timer]struct{})
+	}
+	tw.conns[connID][t] = struct{}{}
+	return t
+}
+
+// 取消链接的所有定时器，链接Stop时自动调用
+func (tw *TimingWheel) CancelConn(connID uint32) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	for t := range tw.conns[connID] {
+		delete(t.slot, t)
+		t.slot = nil
+	}
+	delete(tw.conns, connID)
+}
+
+// 按到期时间将定时器放入对应层的槽中，调用者需持有锁
+func (tw *TimingWheel) add(t *Timer) {
+	//下放时恰好到期的定时器放入当前槽，随后在本格执行
+	expire := t.expire
+	if expire < tw.now {
+		expire = tw.now
+	}
+	delta := expire - tw.now
+
+	level := 0
+	for level < timerLevels-1 && delta >= 1<<(timerSlotBits*(level+1)) {
+		level++
+	}
+	//超出最高层跨度的放在最高层最远的槽中，取出时再重新放入
+	if delta >= 1<<(timerSlotBits*timerLevels) {
+		expire = tw.now + 1<<(timerSlotBits*timerLevels) - 1
+	}
+	t.slot = tw.slots[level][(expire>>(timerSlotBits*level))&timerSlotMask]
+	t.slot[t] = struct{}{}
+}
+
+// 将定时器从槽及链接的定时器集合中删除，调用者需持有锁
+func (tw *TimingWheel) remove(t *Timer) {
+	delete(t.slot, t)
+	t.slot = nil
+	if timers, ok := tw.conns[t.connID]; ok {
+		delete(timers, t)
+		if len(timers) == 0 {
+			delete(tw.conns, t.connID)
+		}
+	}
+}
+
+// 推进时间轮到第target格，执行到期的定时器
+func (tw *TimingWheel) advanceTo(target uint64) {
+	//在锁外执行，回调中可以再添加或取消定时器
+	for _, t := range tw.expire(target) {
+		go t.f()
+	}
+}
+
+// 推进时间轮到第target格，取出到期的定时器
+func (tw *TimingWheel) expire(target uint64) []*Timer {
+	var expired []*Timer
+
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	for tw.now < target {
+		tw.now++
+		//低层转完一圈，逐层把高层当前槽中的定时器下放
+		for level := 1; level < timerLevels; level++ {
+			if (tw.now>>(timerSlotBits*(level-1)))&timerSlotMask != 0 {
+				break
+			}
+			tw.cascade(level, (tw.now>>(timerSlotBits*level))&timerSlotMask)
+		}
+
+		slot := tw.slots[0][tw.now&timerSlotMask]
+		for t := range slot {
+			delete(slot, t)
+			//超出最高层跨度的定时器还没有真正到期
+			if t.expire > tw.now {
+				tw.add(t)
+				continue
+			}
+			tw.remove(t)
+			expired = append(expired, t)
+		}
+	}
+	return expired
+}
+
+// 将高层一个槽中的定时器重新放入时间轮，调用者需持有锁
+func (tw *TimingWheel) cascade(level int, index uint64) {
+	slot := tw.slots[level][index]
+	for t := range slot {
+		delete(slot, t)
+		tw.add(t)
+	}
+}
+
+// 添加一个定时器，d之后在新的Goroutine中执行f，链接断开时自动取消
+// 链接已经关闭时不再添加，返回一个已经停止的定时器
+func (c *Connection) AfterFunc(d time.Duration, f func()) ziface.ITimer {
+	if tw, ok := c.TcpServer.GetTimerWheel().(*TimingWheel); ok {
+		return tw.afterFunc(c.ConnID, d, f, c.isClosed)
+	}
+	return c.TcpServer.GetTimerWheel().AfterFunc(c.ConnID, d, f)
+}
+
+// 添加一个定时器，d之后在链接对应的Worker中执行f，与该链接的消息处理串行
+// 没有开启工作池时与AfterFunc相同
+func (c *Connection) AfterFuncOnWorker(d time.Duration, f func()) ziface.ITimer {
+	return c.AfterFunc(d, func() {
+		if utils.GlobalObject.WorkerPoolSize == 0 {
+			f()
+			return
+		}
+		c.MsgHandler.SendMsgToTaskQueue(&funcRequest{conn: c, f: f})
+	})
+}
@@ -0,0 +1,58 @@
+package znet
+
+import (
+	"testing"
+	"time"
+)
+
+// 分层时间轮的下放、到期及取消
+func TestTimingWheel(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond)
+
+	delays := []uint64{1, 63, 64, 65, 4095, 4096, 4097, 300000, 20000000}
+	var timers []*Timer
+	for _, ticks := range delays {
+		timers = append(timers, tw.afterFunc(1, time.Duration(ticks)*time.Millisecond, nil, nil))
+	}
+	stopped := tw.AfterFunc(2, 10*time.Millisecond, nil)
+	tw.AfterFunc(3, 10*time.Millisecond, nil)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop")
+	}
+	tw.CancelConn(3)
+
+	//不启动ticker，直接推进，检查每个定时器恰好在到期的那一格取出
+	for i, ticks := range delays {
+		target := timers[i].expire
+		if target < ticks {
+			t.Fatalf("timer %d expire %d too early", ticks, target)
+		}
+		if expired := tw.expire(target - 1); len(expired) != 0 {
+			t.Fatalf("timer %d expired at tick %d", expired[0].expire, tw.now)
+		}
+		expired := tw.expire(target)
+		if len(expired) != 1 || expired[0] != timers[i] {
+			t.Fatalf("tick %d expired %d timers", target, len(expired))
+		}
+	}
+	if len(tw.conns) != 0 {
+		t.Fatalf("conns = %d", len(tw.conns))
+	}
+}
+
+// tw.now落后于实际时间时，到期时间仍按实际时间计算，不会提前执行
+func TestTimingWheel_lag(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond)
+	tw.start = tw.start.Add(-50 * time.Millisecond)
+
+	timer := tw.afterFunc(1, 10*time.Millisecond, nil, nil)
+	if timer.expire < 60 {
+		t.Fatalf("expire = %d, want >= 60", timer.expire)
+	}
+
+	//链接已经关闭时不再添加
+	closed := tw.afterFunc(2, 10*time.Millisecond, nil, func() bool { return true })
+	if closed.Stop() || len(tw.conns[2]) != 0 {
+		t.Fatal("timer added for closed conn")
+	}
+}